@@ -0,0 +1,354 @@
+package graphitemetrictest
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Pos represents a position in the rule file.
+type Pos struct {
+	Line int // line number, starting at 1.
+	Col  int // column number in runes, starting at 1.
+}
+
+// String returns the representation of the position.
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// File represents a syntax tree of the rule file.
+type File struct {
+	Name  string
+	Stmts []Stmt
+}
+
+// Stmt represents a line of the rule file.
+type Stmt interface {
+	Pos() Pos
+	stmtNode()
+}
+
+// BlankLine represents an empty line; it separates blocks of rules.
+type BlankLine struct {
+	Start Pos
+}
+
+// Comment represents a comment; Text contains leading "//".
+type Comment struct {
+	Start Pos
+	Text  string
+}
+
+// RuleStmt represents a line of the rule.
+type RuleStmt struct {
+	Start    Pos
+	Severity string // severity such as "warn" without '@'; it is empty if not annotated.
+	Optional bool
+	Removed  bool // "-path" removes rules of the path in overlays; see Merge.
+	Path     string
+	Labels   []string // labels such as "linux" for selecting rules.
+	Exprs    []ExprNode
+	Comment  *Comment // trailing comment on the line.
+}
+
+// IncludeStmt represents an include directive such as `include "common.rules"`.
+type IncludeStmt struct {
+	Start   Pos
+	Pattern string   // file name or glob pattern; it is relative to the including file.
+	Comment *Comment // trailing comment on the line.
+}
+
+// PrefixBlock represents a block such as "prefix custom.mysql {...}".
+// Paths of rules in the block are prefixed by Prefix.
+type PrefixBlock struct {
+	Start      Pos
+	Prefix     string
+	Comment    *Comment // trailing comment after '{'.
+	Stmts      []Stmt
+	End        Pos      // position of '}'.
+	EndComment *Comment // trailing comment after '}'.
+}
+
+// TemplateBlock represents a definition of the template such as "template nic {...}".
+// Statements in the template are evaluated where the template is used.
+type TemplateBlock struct {
+	Start      Pos
+	Name       string
+	Comment    *Comment // trailing comment after '{'.
+	Stmts      []Stmt
+	End        Pos      // position of '}'.
+	EndComment *Comment // trailing comment after '}'.
+}
+
+// GroupBlock represents a group of rules such as "oneof {...}" or "atleast 2 {...}".
+// The number of matched rules in the block must be between Min and Max.
+type GroupBlock struct {
+	Start      Pos
+	Severity   string // severity such as "warn" without '@'; it is empty if not annotated.
+	Min        int
+	Max        int      // if Max is zero, the number is unlimited.
+	Comment    *Comment // trailing comment after '{'.
+	Stmts      []Stmt
+	End        Pos      // position of '}'.
+	EndComment *Comment // trailing comment after '}'.
+}
+
+// UseStmt represents an instantiation of the template such as "use nic at custom.interfaces.#".
+// It is equivalent to the prefix block with the template's statements.
+type UseStmt struct {
+	Start   Pos
+	Name    string
+	Path    string
+	Comment *Comment // trailing comment on the line.
+}
+
+// WhenStmt represents a conditional rule such as "when a.role ==1 require a.lag >=0".
+// Rule is required only if any metric matched to Path satisfies Exprs.
+type WhenStmt struct {
+	Start    Pos
+	Severity string // severity such as "warn" without '@'; it is empty if not annotated.
+	Path     string
+	Exprs    []ExprNode
+	Rule     *RuleStmt
+}
+
+// LetStmt represents a definition of the variable such as "let MAXCONN = 10000".
+type LetStmt struct {
+	Start   Pos
+	Name    string
+	Value   *NumberLit
+	Comment *Comment // trailing comment on the line.
+}
+
+// ExprNode represents an expression in the rule.
+type ExprNode interface {
+	Pos() Pos
+	exprNode()
+}
+
+// CmpExpr represents a comparison such as ">=0" or "value/1024 <100".
+type CmpExpr struct {
+	Start Pos
+	Left  ExprNode // value compared with X; it is nil for the metric value such as ">=0".
+	Op    Operator
+	X     *NumberLit
+}
+
+// AssertExpr represents an assertion of the value such as "int".
+type AssertExpr struct {
+	Start Pos
+	Op    Operator
+}
+
+// ValueRef represents the metric value referred by "value".
+type ValueRef struct {
+	Start Pos
+}
+
+// CallExpr represents a function call such as "abs(value)".
+type CallExpr struct {
+	Start Pos
+	Func  string
+	X     ExprNode
+}
+
+// UnaryExpr represents a unary arithmetic operation such as "-value".
+type UnaryExpr struct {
+	Start Pos
+	Op    string // "-" or "+".
+	X     ExprNode
+}
+
+// BinaryExpr represents an arithmetic operation such as "value/1024".
+type BinaryExpr struct {
+	Start Pos
+	X     ExprNode
+	Op    string // "+", "-", "*", "/" or "%".
+	Y     ExprNode
+}
+
+// OrExpr represents alternatives such as "<0 | >=3, <5".
+// Each alternative is a list of expressions that all must be satisfied.
+type OrExpr struct {
+	Start Pos
+	Alts  [][]ExprNode
+}
+
+// ParenExpr represents expressions in parentheses.
+// In arithmetic operations, Exprs has exactly one expression.
+type ParenExpr struct {
+	Start Pos
+	Exprs []ExprNode
+}
+
+// RangeExpr represents a range such as "0..100", or an interval such as "(0, 6]".
+// The range includes both ends.
+type RangeExpr struct {
+	Start Pos
+	Low   *NumberLit
+	High  *NumberLit
+	Open  string // "[" or "("; it is empty for the range.
+	Close string // "]" or ")"; it is empty for the range.
+}
+
+// NumberLit represents a number or a reference to the variable such as "$MAXCONN".
+type NumberLit struct {
+	Start Pos
+	Lit   string  // number as written in the file such as "10KiB"; it is empty if Var is set.
+	Var   string  // name of the variable without '$'.
+	Value float64 // value of Lit multiplied by its unit.
+}
+
+// Pos returns the position of the first character of the node.
+func (s *BlankLine) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *Comment) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *RuleStmt) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *IncludeStmt) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *PrefixBlock) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *TemplateBlock) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *GroupBlock) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *UseStmt) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *LetStmt) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (s *WhenStmt) Pos() Pos { return s.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *CmpExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *AssertExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *ValueRef) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *CallExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *UnaryExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *BinaryExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *OrExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *ParenExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *RangeExpr) Pos() Pos { return e.Start }
+
+// Pos returns the position of the first character of the node.
+func (e *NumberLit) Pos() Pos { return e.Start }
+
+func (*BlankLine) stmtNode()     {}
+func (*Comment) stmtNode()       {}
+func (*RuleStmt) stmtNode()      {}
+func (*IncludeStmt) stmtNode()   {}
+func (*PrefixBlock) stmtNode()   {}
+func (*TemplateBlock) stmtNode() {}
+func (*GroupBlock) stmtNode()    {}
+func (*UseStmt) stmtNode()       {}
+func (*LetStmt) stmtNode()       {}
+func (*WhenStmt) stmtNode()      {}
+func (*CmpExpr) exprNode()       {}
+func (*OrExpr) exprNode()        {}
+func (*ParenExpr) exprNode()     {}
+func (*RangeExpr) exprNode()     {}
+func (*AssertExpr) exprNode()    {}
+func (*ValueRef) exprNode()      {}
+func (*CallExpr) exprNode()      {}
+func (*UnaryExpr) exprNode()     {}
+func (*BinaryExpr) exprNode()    {}
+func (*NumberLit) exprNode()     {}
+
+// Rules returns rules described in f.
+//
+// It returns an error if f contains include directives, because f does not know where included files are.
+// Use ReadRulesFS to resolve them.
+func (f *File) Rules() ([]*Rule, error) {
+	var l loader
+	return l.eval(f, &scope{})
+}
+
+// SortRules sorts rules by their path within each blocks separated by blank lines.
+// Comment lines just before a rule are moved together with the rule.
+// Other statements are not moved; rules are sorted between them.
+func (f *File) SortRules() {
+	sortStmts(f.Stmts)
+}
+
+func sortStmts(stmts []Stmt) {
+	i := 0
+	for j, stmt := range stmts {
+		if _, ok := stmt.(*BlankLine); ok {
+			sortBlock(stmts[i:j])
+			i = j + 1
+		}
+	}
+	sortBlock(stmts[i:])
+}
+
+func sortBlock(block []Stmt) {
+	type item struct {
+		stmts []Stmt
+		path  string
+	}
+	var (
+		items []*item
+		stmts []Stmt
+	)
+	a := make([]Stmt, 0, len(block))
+	flush := func() {
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].path < items[j].path
+		})
+		for _, v := range items {
+			a = append(a, v.stmts...)
+		}
+		a = append(a, stmts...)
+		items = nil
+		stmts = nil
+	}
+	for _, stmt := range block {
+		switch s := stmt.(type) {
+		case *Comment:
+			stmts = append(stmts, s)
+		case *RuleStmt:
+			stmts = append(stmts, s)
+			items = append(items, &item{stmts: stmts, path: s.Path})
+			stmts = nil
+		default:
+			switch b := s.(type) {
+			case *PrefixBlock:
+				sortStmts(b.Stmts)
+			case *TemplateBlock:
+				sortStmts(b.Stmts)
+			case *GroupBlock:
+				sortStmts(b.Stmts)
+			}
+			flush()
+			a = append(a, s)
+		}
+	}
+	flush()
+	copy(block, a)
+}
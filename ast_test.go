@@ -0,0 +1,97 @@
+package graphitemetrictest
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFile(t *testing.T) {
+	in := "// doc\n\n~a.b.c\t>0, <=.5 // note\n"
+	want := &File{
+		Name: "test",
+		Stmts: []Stmt{
+			&Comment{Start: Pos{1, 1}, Text: "// doc"},
+			&BlankLine{Start: Pos{2, 1}},
+			&RuleStmt{
+				Start:    Pos{3, 1},
+				Optional: true,
+				Path:     "a.b.c",
+				Exprs: []ExprNode{
+					&CmpExpr{Start: Pos{3, 8}, Op: GreaterThan, X: &NumberLit{Start: Pos{3, 9}, Lit: "0", Value: 0}},
+					&CmpExpr{Start: Pos{3, 12}, Op: LessEqual, X: &NumberLit{Start: Pos{3, 14}, Lit: ".5", Value: 0.5}},
+				},
+				Comment: &Comment{Start: Pos{3, 17}, Text: "// note"},
+			},
+		},
+	}
+	f, err := ParseFile("test", strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ParseFile(%q): %v", in, err)
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("ParseFile(%q) = %+v; want %+v", in, f, want)
+	}
+}
+
+func TestParseFile_error(t *testing.T) {
+	tests := []struct {
+		in   string
+		line int
+	}{
+		{in: "a.b.c >", line: 1},
+		{in: "a.b.c\n\n<0", line: 3},
+		{in: "a.b.c >0 <1", line: 1},
+		{in: "a.b.c\n~\n", line: 2},
+		{in: "a.b.c / x", line: 1},
+	}
+	for _, tt := range tests {
+		_, err := ParseFile("test", strings.NewReader(tt.in))
+		if err == nil {
+			t.Errorf("ParseFile(%q) should return an error", tt.in)
+			continue
+		}
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("ParseFile(%q) = %T; want *ParseError", tt.in, err)
+			continue
+		}
+		if e.Line != tt.line {
+			t.Errorf("ParseFile(%q): Line = %d; want %d", tt.in, e.Line, tt.line)
+		}
+	}
+}
+
+func TestFile_SortRules(t *testing.T) {
+	in := `// header
+c.c >0
+// doc of a
+a.a
+// trailer
+
+~b.b
+a.b // x
+`
+	want := `// doc of a
+a.a
+// header
+c.c >0
+// trailer
+
+a.b // x
+~b.b
+`
+	f, err := ParseFile("test", strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	f.SortRules()
+	var buf bytes.Buffer
+	if err := Fprint(&buf, f); err != nil {
+		t.Fatalf("Fprint: %v", err)
+	}
+	if s := buf.String(); s != want {
+		t.Errorf("SortRules(%q) = %q; want %q", in, s, want)
+	}
+}
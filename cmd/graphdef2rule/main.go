@@ -9,6 +9,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/lufia/graphitemetrictest"
 )
 
 type GraphDefMetric struct {
@@ -58,13 +60,23 @@ func GenerateRule(r io.Reader) {
 	if err := json.NewDecoder(r).Decode(&graphDefs); err != nil {
 		log.Fatalf("cannot decode a JSON: %v", err)
 	}
+	var rules []*graphitemetrictest.Rule
 	for key, g := range graphDefs.Graphs {
 		for _, m := range g.Metrics {
-			exprs := ">=0"
+			exprs := []*graphitemetrictest.Expr{
+				{Op: graphitemetrictest.GreaterEqual, Value: 0},
+			}
 			if g.Unit == "percentage" {
-				exprs = ">=0, <=100"
+				exprs = append(exprs, &graphitemetrictest.Expr{Op: graphitemetrictest.LessEqual, Value: 100})
 			}
-			fmt.Printf("%s.%s\t%s\n", key, m.Name, exprs)
+			rules = append(rules, &graphitemetrictest.Rule{
+				Required: true,
+				Path:     key + "." + m.Name,
+				Exprs:    exprs,
+			})
 		}
 	}
+	if err := graphitemetrictest.WriteRules(os.Stdout, rules); err != nil {
+		log.Fatalf("cannot write rules: %v", err)
+	}
 }
@@ -1,17 +1,57 @@
 // graphite-metric-test is a verifier for Graphite Plaintext Protocol format.
 //
-// Usage
+// # Usage
 //
-//	graphite-metric-test [-f rule] [file ...]
+//	graphite-metric-test [-naming] [-pickle] [-coverage] [-select expr] [-unexpected severity] [-update[=required]] [-f rule] [file ...]
+//	graphite-metric-test -lint [-select expr] [-f rule]
+//	graphite-metric-test -generate [-pickle] [-variants n] [-headroom ratio] [file ...]
 //
 // graphite-metric-test reads the rules, reads metrics from stdin by default, and verify them.
 // Then it ends up reports missing metrics, unexpected metrics or out of range metrics.
+// For each unexpected metrics, it suggests the rule that has the nearest path,
+// and it reports the missing rule that is likely renamed to the unexpected metric.
 //
-// Options
+// # Options
 //
 // The -f option is a file contains rules with metric path patterns and metric value ranges.
+// The -f option can be repeated; the second and later files are overlays of preceding files.
+// A rule in the overlay replaces rules that have the same path, and '-path' removes them.
 //
-// The Rules
+//	graphite-metric-test -f base.rules -f production.rules
+//
+// If the file name ends with .json, .yaml or .yml, the rules are read in JSON or YAML; see graphitemetrictest.JSONFormat.
+//
+// The -pickle option reads metrics in the pickle protocol instead of the plaintext protocol.
+//
+// The -naming option checks metric paths against naming conventions too;
+// paths must be lowercase, must not have numeric or UUID-like segments,
+// and each segments must fit in the file name limit of whisper.
+//
+// The -select option activates only rules that have labels satisfying the expression such as 'linux && !container'.
+// The expression consists of labels, '!', '&&', '||' and parentheses. Rules without labels are always active.
+//
+// The -coverage option prints the number of metrics matched to each rules, its percentage of all metrics,
+// and the minimum, maximum and mean of their values to the standard output.
+// Rules never matched, including optional rules, are printed with zero.
+//
+// The -lint option checks the rules instead of metrics. It reports expressions never satisfied,
+// duplicate rules, rules shadowed by other rules, rules never matched, and required rules made redundant by optional rules.
+//
+// The -generate option reads metrics and writes rules inferred from them to the standard output
+// instead of checking metrics. Segments consisted of only digits are collapsed into '#'.
+// Other segments are collapsed into '#' if at least n siblings, specified by the -variants option, have the same sub paths.
+// The range of each rules has the headroom, specified by the -headroom option, in the ratio to the observed range.
+//
+// The -update option appends paths of unexpected metrics to the rule file as optional rules,
+// or required rules with -update=required, instead of reporting them as errors.
+// The rule file is the last one of the -f options, and it must be written in the text format.
+// Existing lines of the file are kept intact.
+// With the -select option, metrics matched to rules that are not selected are reported as unexpected
+// rather than appended, because their rules already exist.
+//
+// The -unexpected option is the severity of unexpected metrics; error (default), warn or info.
+//
+// # The Rules
 //
 // The rule described in the rule file each lines is a pair of metric path pattern and value range.
 //
@@ -21,29 +61,151 @@
 //	~local.network.tx.bytes	>0 // path starting with ~ is optional
 //	local.uptime // no range; it checks path existence but the value is not checked
 //
-// If you want to check metrics with OR condition, you can put multiple lines with same path pattern.
+// The labels in brackets after the path, such as "custom.cpu.steal[linux]" or "custom.cpu.steal [linux]", are used to select rules with the -select option.
+//
+//	custom.mysql.innodb.rows.read [mysql8]	>=0
+//	custom.cpu.steal [linux,vm]		>=0
+//
+// The comment lines just before the rule can annotate the rule with
+// the description (@desc), the owner (@owner) and the links to documents (@link).
+// They are shown in reports of the rule.
+// The annotations before the oneof or atleast block are applied to the rules in the block.
+//
+//	// @desc the number of slow queries per second
+//	// @owner dba-team
+//	// @link https://example.com/runbooks/mysql-slow-queries
+//	custom.mysql.slow_queries	<10
+//
+// The rule file can include other rule files.
+// The file name is relative to the including file unless it is an absolute path, and it can contain glob patterns.
+// Constants and templates defined in included files are available in the including file.
+//
+//	include "common.rules"
+//	include "plugins/*.rules"
+//
+// Rules in a prefix block are prefixed by the path of the block.
+// Blocks can be nested.
+//
+//	prefix custom.mysql.innodb {
+//		buffer_pool.pages.free	>=0
+//		~rows.read		>=0
+//	}
+//
+// The let statement defines a named constant, and $NAME refers it in place of a number.
+// If NAME is not defined in the rule file, it refers the environment variable.
+//
+//	let MAXCONN = 10000
+//	custom.mysql.connections	<=$MAXCONN
+//	custom.mysql.threads		<=$MAX_THREADS // from the environment
+//
+// The template defines reusable rules, and the use statement instantiates them at a path.
+// Statements can be separated by ';' followed by a space as well as newlines.
+// Otherwise ';' is a part of the path, such as tags in "custom.disks.reads;device=sda".
+//
+//	template nic { rx.bytes >=0; tx.bytes >=0 }
+//	use nic at custom.interfaces.#
+//	use nic at custom.bond.#
+//
+// Rules in the oneof block are exclusive; exactly one of them must be matched.
+// At least N rules in the atleast block must be matched.
+// Rules in these blocks are not required individually.
+//
+//	oneof {
+//		custom.net.ipv4.*
+//		custom.net.ipv6.*
+//	}
+//	atleast 2 { custom.a; custom.b; custom.c }
+//
+// The when statement makes the rule required only if any metric matched to the path satisfies the expressions.
+// Without expressions, it is satisfied if the path exists.
+//
+//	when custom.redis.replication.role ==1 require custom.redis.replication.lag >=0
+//
+// If you want to check metrics with OR condition, separate alternatives by '|'.
+// The ',' binds tighter than '|', and parentheses group expressions.
+//
+//	local.signal.level	>=0, <2 | >=3, <5
+//	local.signal.delta	>=-10, (<-1 | >1)
+//
+// # The Operators
+//
+// The operators are '<=', '<', '>=', '>', '==' and '!='.
+// The ',' means AND, and the '|' means OR.
+//
+// The left-hand side of the operator is the metric value by default.
+// It can be an arithmetic operation of value with '+', '-', '*', '/', '%' (modulo), and functions abs, floor and ceil.
+//
+//	custom.memory.used	value/1024 <100
+//	custom.temp.delta	abs(value) <5
+//	custom.disk.size	value % 512 ==0 // multiple of 512
+//
+// The assertions check the kind of the value:
+// 'int' (an integer), 'finite' (neither NaN nor infinity), 'notnan', 'nonneg' (zero or positive),
+// and 'safe' (the absolute value is not greater than 2^53, where integers are exact in float64).
+// The 'safe' does not require an integer; use 'int, safe' for exact integers.
+// Metrics of which values are NaN or infinity are reported as warnings regardless of rules.
 //
-//	local.signal.level		>=0, <2
-//	local.signal.level		>=3, <5
+//	custom.requests.count	int, nonneg, safe
+//	custom.hit.ratio	finite, 0..1
 //
-// The Operators
+// The range 'low..high' includes both ends. The interval such as '[low, high)' or '(low, high]'
+// excludes the end next to the parenthesis.
 //
-// The operators are '<=', '<', '>=' and '>'.
+//	local.random.diceroll	1..6
+//	local.cpu.usage		[0, 100)
+//
+// # The Numbers
+//
+// A number can have an exponent such as 1e3 and a unit suffix.
+// Sizes are converted to bytes: B, KB, MB, GB, TB (powers of 1000) and KiB, MiB, GiB, TiB (powers of 1024).
+// Durations are converted to seconds: ns, us, ms, s, min, h and d.
+// The '%' does not change the value; 90% is 90. The '%' followed by an operand such as 100%7 is the modulo.
+//
+//	custom.mysql.buffer_pool.size	<=1GiB
+//	custom.http.latency		<=250ms
+//	custom.disk.usage		<=90%
+//
+// # The Severities
+//
+// The rule, the when statement and the oneof or atleast block can be annotated with the severity;
+// @error (default), @warn or @info. Rules in the block inherit the severity of the block.
+// Warnings and infos are reported but graphite-metric-test exits with zero if there are no errors.
+//
+//	@warn custom.mysql.slow_queries	<10
+//	@info oneof {
+//		custom.net.ipv4.*
+//		custom.net.ipv6.*
+//	}
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/lufia/graphitemetrictest"
 )
 
 var (
-	flagFile = flag.String("f", "metricrules", "a pattern `file` for metrics")
+	flagFiles  fileList
+	flagNaming = flag.Bool("naming", false, "check naming conventions of metric paths")
+	flagPickle = flag.Bool("pickle", false, "read metrics in the pickle protocol")
+	flagSelect = flag.String("select", "", "activate rules that have labels satisfying the `expr`")
+	flagLint   = flag.Bool("lint", false, "check the rules instead of metrics")
+	flagCover  = flag.Bool("coverage", false, "print the number of metrics matched to each rules")
+	flagUpdate updateMode
+
+	flagGenerate = flag.Bool("generate", false, "write rules inferred from metrics")
+	flagVariants = flag.Int("variants", graphitemetrictest.DefaultInferOptions.MinVariants, "collapse segments if at least `n` siblings have the same sub paths")
+	flagHeadroom = flag.Float64("headroom", graphitemetrictest.DefaultInferOptions.Headroom, "the headroom of ranges in the `ratio` to observed ranges")
+
+	flagUnexpected graphitemetrictest.Severity
 
 	argv0   = filepath.Base(os.Args[0])
 	nerrors int
@@ -54,6 +216,22 @@ func logf(format string, args ...interface{}) {
 	nerrors++
 }
 
+func init() {
+	flag.Var(&flagUpdate, "update", "append unexpected metrics to the rule file as optional rules, or required rules with -update=required")
+	flag.Var(&flagFiles, "f", "a pattern `file` for metrics; it can be repeated to overlay rules (default metricrules)")
+	flag.TextVar(&flagUnexpected, "unexpected", graphitemetrictest.SeverityError, "the `severity` of unexpected metrics")
+}
+
+// reportf reports the invalid data with the severity.
+// Only errors cause the exit status to be non-zero.
+func reportf(s graphitemetrictest.Severity, format string, args ...interface{}) {
+	if s == graphitemetrictest.SeverityError {
+		logf(format, args...)
+		return
+	}
+	log.Printf(s.String()+": "+format, args...)
+}
+
 func usage() {
 	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] [file ...]\n", argv0)
 	flag.PrintDefaults()
@@ -65,51 +243,282 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	f, err := os.Open(*flagFile)
-	if err != nil {
-		log.Fatalf("cannot open %s: %v", *flagFile, err)
+	if *flagGenerate {
+		generateRules()
+		return
 	}
-	rules, err := graphitemetrictest.ReadRules(f)
-	if err != nil {
-		log.Fatalf("cannot parse %s: %v", *flagFile, err)
+	if len(flagFiles) == 0 {
+		flagFiles = fileList{"metricrules"}
+	}
+	var layers [][]*graphitemetrictest.Rule
+	for _, file := range flagFiles {
+		a, err := readRules(file)
+		if err != nil {
+			log.Fatalf("cannot read %s: %v", file, err)
+		}
+		layers = append(layers, a)
+	}
+	rules := graphitemetrictest.Merge(layers[0], layers[1:]...)
+	all := rules
+	if *flagSelect != "" {
+		sel, err := graphitemetrictest.ParseSelector(*flagSelect)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		rules = graphitemetrictest.Select(rules, sel)
+	}
+	if *flagLint {
+		for _, e := range graphitemetrictest.Lint(rules) {
+			logf("%v\n", e)
+		}
+		if nerrors > 0 {
+			os.Exit(1)
+		}
+		return
 	}
-	f.Close()
 
 	if flag.NArg() == 0 {
 		log.SetPrefix(fmt.Sprintf("%s: %s: ", argv0, "<stdin>"))
-		checkMetrics(rules, os.Stdin)
+		checkMetrics(rules, all, os.Stdin)
 	} else {
 		for _, file := range flag.Args() {
-			f, err = os.Open(file)
+			f, err := os.Open(file)
 			if err != nil {
 				logf("cannot open %s: %v", file, err)
 				continue
 			}
 			log.SetPrefix(fmt.Sprintf("%s: %s: ", argv0, file))
-			checkMetrics(rules, f)
+			checkMetrics(rules, all, f)
 			f.Close()
 		}
 	}
+	if len(unexpected) > 0 {
+		log.SetPrefix(fmt.Sprintf("%s: ", argv0))
+		file := flagFiles[len(flagFiles)-1]
+		if err := appendRules(file, unexpected); err != nil {
+			log.Fatalf("cannot update %s: %v", file, err)
+		}
+	}
 	if nerrors > 0 {
 		os.Exit(1)
 	}
 }
 
-func checkMetrics(rules []*graphitemetrictest.Rule, r io.Reader) {
-	metrics, err := graphitemetrictest.ReadMetrics(r)
+// updateMode is a flag.Value for the -update option.
+type updateMode int
+
+const (
+	updateNone updateMode = iota
+	updateOptional
+	updateRequired
+)
+
+func (m *updateMode) String() string {
+	switch *m {
+	case updateOptional:
+		return "true"
+	case updateRequired:
+		return "required"
+	default:
+		return "false"
+	}
+}
+
+func (m *updateMode) Set(s string) error {
+	switch s {
+	case "true", "optional":
+		*m = updateOptional
+	case "required":
+		*m = updateRequired
+	case "false":
+		*m = updateNone
+	default:
+		return fmt.Errorf("unknown mode %q; it must be either optional or required", s)
+	}
+	return nil
+}
+
+func (m *updateMode) IsBoolFlag() bool { return true }
+
+// unexpected holds rules for unexpected metrics to be appended by the -update option.
+var (
+	unexpected     []*graphitemetrictest.Rule
+	unexpectedSeen = make(map[string]bool)
+)
+
+func addUnexpected(m *graphitemetrictest.Metric) {
+	if unexpectedSeen[m.Path] {
+		return
+	}
+	unexpectedSeen[m.Path] = true
+	log.Printf("add a rule for unexpected metric %v\n", m)
+	unexpected = append(unexpected, &graphitemetrictest.Rule{
+		Required: flagUpdate == updateRequired,
+		Path:     m.Path,
+	})
+}
+
+// appendRules appends rules to the end of the rule file.
+func appendRules(file string, rules []*graphitemetrictest.Rule) error {
+	if graphitemetrictest.FormatOf(file) != graphitemetrictest.TextFormat {
+		return errors.New("only the text format can be updated")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	if err := graphitemetrictest.WriteRules(&buf, rules); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fileList is a flag.Value that accumulates file names.
+type fileList []string
+
+func (l *fileList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *fileList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// readRules reads rules from file.
+// Included files are allowed to be placed anywhere in the file system.
+func readRules(file string) ([]*graphitemetrictest.Rule, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.VolumeName(abs) + string(filepath.Separator)
+	name, err := filepath.Rel(root, abs)
+	if err != nil {
+		return nil, err
+	}
+	return graphitemetrictest.ReadRulesFS(os.DirFS(root), filepath.ToSlash(name))
+}
+
+func readMetrics(r io.Reader) ([]*graphitemetrictest.Metric, error) {
+	if *flagPickle {
+		return graphitemetrictest.ReadPickle(r)
+	}
+	return graphitemetrictest.ReadMetrics(r)
+}
+
+// generateRules writes rules inferred from all metrics in the files.
+func generateRules() {
+	var metrics []*graphitemetrictest.Metric
+	if flag.NArg() == 0 {
+		a, err := readMetrics(os.Stdin)
+		if err != nil {
+			log.Fatalf("cannot parse metrics: %v", err)
+		}
+		metrics = a
+	}
+	for _, file := range flag.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatalf("cannot open %s: %v", file, err)
+		}
+		a, err := readMetrics(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: cannot parse metrics: %v", file, err)
+		}
+		metrics = append(metrics, a...)
+	}
+	rules := graphitemetrictest.InferRules(metrics, &graphitemetrictest.InferOptions{
+		MinVariants: *flagVariants,
+		Headroom:    *flagHeadroom,
+	})
+	if err := graphitemetrictest.WriteRules(os.Stdout, rules); err != nil {
+		log.Fatalf("cannot write rules: %v", err)
+	}
+}
+
+// checkMetrics checks metrics read from r with rules.
+// The all is rules before selected by the -select option.
+func checkMetrics(rules, all []*graphitemetrictest.Rule, r io.Reader) {
+	metrics, err := readMetrics(r)
 	if err != nil {
 		logf("cannot parse metrics: %v", err)
 		return
 	}
 
+	if *flagNaming {
+		for _, e := range graphitemetrictest.DefaultNamingPolicy.Check(metrics) {
+			logf("%v\n", e)
+		}
+	}
+
+	for _, m := range graphitemetrictest.NonFinite(metrics) {
+		reportf(graphitemetrictest.SeverityWarning, "metric %v has a non-finite value\n", m)
+	}
+
 	diffs := graphitemetrictest.Diff(rules, metrics)
+	isNew := func(m *graphitemetrictest.Metric) bool { return true }
+	if flagUpdate != updateNone && len(all) != len(rules) {
+		// metrics matched to rules that are not selected are not new.
+		a := make(map[*graphitemetrictest.Metric]bool)
+		for _, d := range graphitemetrictest.Diff(all, metrics) {
+			if d.Rule == nil && d.Metric != nil {
+				a[d.Metric] = true
+			}
+		}
+		isNew = func(m *graphitemetrictest.Metric) bool { return a[m] }
+	}
 	for _, d := range diffs {
-		if d.Rule != nil && d.Metric != nil {
-			logf("metric %v is violated to rule %v\n", d.Metric, d.Rule)
+		if d.Group != nil {
+			reportf(d.Severity, "group %v is matched %d rules\n", d.Group, d.Count)
+		} else if d.Rule != nil && d.Metric != nil {
+			reportf(d.Severity, "metric %v is violated to rule %v\n%s", d.Metric, d.Rule, annotations(d.Rule))
+		} else if d.Rule == nil && flagUpdate != updateNone && isNew(d.Metric) {
+			addUnexpected(d.Metric)
+		} else if d.Rule == nil && d.Suggestion != nil {
+			reportf(flagUnexpected, "found unexpected metric %v; did you mean %s?\n", d.Metric, d.Suggestion.Path)
 		} else if d.Rule == nil {
-			logf("found unexpected metric %v\n", d.Metric)
+			reportf(flagUnexpected, "found unexpected metric %v\n", d.Metric)
+		} else if d.Renamed != nil {
+			reportf(d.Severity, "rule %v is not matched any metrics; it may be renamed to %s\n%s", d.Rule, d.Renamed.Path, annotations(d.Rule))
 		} else {
-			logf("rule %v is not matched any metrics\n", d.Rule)
+			reportf(d.Severity, "rule %v is not matched any metrics\n%s", d.Rule, annotations(d.Rule))
 		}
 	}
+
+	if *flagCover {
+		for _, c := range graphitemetrictest.Coverage(rules, metrics) {
+			fmt.Printf("%v\t%d\t%.1f%%\tmin=%g\tmax=%g\tmean=%g\n", c.Rule, c.Count, c.Fraction*100, c.Min, c.Max, c.Mean)
+		}
+	}
+}
+
+// annotations returns indented lines of the description, the owner and the links of r.
+func annotations(r *graphitemetrictest.Rule) string {
+	var b strings.Builder
+	for _, s := range strings.Split(r.Description, "\n") {
+		if s != "" {
+			fmt.Fprintf(&b, "\t%s\n", s)
+		}
+	}
+	if r.Owner != "" {
+		fmt.Fprintf(&b, "\towner: %s\n", r.Owner)
+	}
+	for _, s := range r.Links {
+		fmt.Fprintf(&b, "\tsee %s\n", s)
+	}
+	return b.String()
 }
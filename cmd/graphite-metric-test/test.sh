@@ -1,3 +1,18 @@
 #!/bin/sh
 
+set -e
+
 go run . -f testdata/rules1 testdata/metrics1
+
+# -update appends unexpected metrics, but not metrics matched to rules that are not selected.
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+cp testdata/rules2 "$tmp/rules"
+go run . -update -select darwin -unexpected warn -f "$tmp/rules" testdata/metrics2
+go run . -update=required -select linux -f "$tmp/rules" testdata/metrics3
+diff -u testdata/rules2.golden "$tmp/rules"
+
+if go run . -update=unknown -f "$tmp/rules" testdata/metrics3 2>/dev/null; then
+	echo "-update=unknown should be failed" >&2
+	exit 1
+fi
@@ -0,0 +1,155 @@
+// graphite-rulefmt formats rule files of graphite-metric-test.
+//
+// # Usage
+//
+//	graphite-rulefmt [-l] [-w] [-d] [file ...]
+//
+// Without an explicit file, it formats the standard input and writes the result to the standard output.
+// By default, it writes formatted rules to the standard output.
+//
+// The formatter aligns paths, expressions and trailing comments in columns,
+// normalizes spaces around operators, and sorts rules by their path within each blocks separated by blank lines.
+// Comment lines just before a rule are kept together with the rule.
+//
+// # Options
+//
+// The -l option lists files whose formatting differs from graphite-rulefmt's.
+//
+// The -w option writes the result to the source file instead of the standard output.
+//
+// The -d option displays diffs instead of rewriting files.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/lufia/graphitemetrictest"
+)
+
+var (
+	flagList  = flag.Bool("l", false, "list files whose formatting differs from graphite-rulefmt's")
+	flagWrite = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	flagDiff  = flag.Bool("d", false, "display diffs instead of rewriting files")
+
+	argv0   = filepath.Base(os.Args[0])
+	nerrors int
+)
+
+func logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	nerrors++
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] [file ...]\n", argv0)
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix(fmt.Sprintf("%s: ", argv0))
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *flagWrite {
+			log.Fatalf("cannot use -w with standard input")
+		}
+		if err := processFile("<stdin>", os.Stdin, os.Stdout); err != nil {
+			logf("%v", err)
+		}
+	} else {
+		for _, file := range flag.Args() {
+			f, err := os.Open(file)
+			if err != nil {
+				logf("cannot open %s: %v", file, err)
+				continue
+			}
+			if err := processFile(file, f, os.Stdout); err != nil {
+				logf("%v", err)
+			}
+			f.Close()
+		}
+	}
+	if nerrors > 0 {
+		os.Exit(1)
+	}
+}
+
+func processFile(filename string, in io.Reader, out io.Writer) error {
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", filename, err)
+	}
+	f, err := graphitemetrictest.ParseFile(filename, bytes.NewReader(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	f.SortRules()
+
+	var buf bytes.Buffer
+	if err := graphitemetrictest.Fprint(&buf, f); err != nil {
+		return err
+	}
+	res := buf.Bytes()
+
+	if !bytes.Equal(src, res) {
+		if *flagList {
+			fmt.Fprintln(out, filename)
+		}
+		if *flagWrite {
+			fi, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filename, res, fi.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if *flagDiff {
+			d, err := diff(filename, src, res)
+			if err != nil {
+				return fmt.Errorf("cannot compute diff: %w", err)
+			}
+			out.Write(d)
+		}
+	}
+	if !*flagList && !*flagWrite && !*flagDiff {
+		if _, err := out.Write(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func diff(filename string, b1, b2 []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", argv0)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	f1 := filepath.Join(dir, "orig")
+	if err := os.WriteFile(f1, b1, 0o644); err != nil {
+		return nil, err
+	}
+	f2 := filepath.Join(dir, "new")
+	if err := os.WriteFile(f2, b2, 0o644); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("diff", "-u", "-L", filename+".orig", "-L", filename, f1, f2)
+	data, err := cmd.Output()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match.
+		// Ignore that failure as long as we get output.
+		return data, nil
+	}
+	return data, err
+}
@@ -0,0 +1,90 @@
+package graphitemetrictest
+
+import "math"
+
+// RuleCoverage represents how many metrics are matched to the rule, and statistics of their values.
+type RuleCoverage struct {
+	Rule     *Rule
+	Count    int     // number of metrics matched to the rule.
+	Fraction float64 // fraction of Count in all metrics; it is zero if there are no metrics.
+
+	// Statistics of the values matched to the rule regardless of whether the value is valid.
+	// NaN values are not counted. They are NaN if there are no values.
+	Min  float64
+	Max  float64
+	Mean float64
+}
+
+// Coverage returns the coverage of each rules in the same order as rules.
+//
+// A metric is matched to the rule in the same way as Diff regardless of its value,
+// so rules that have the same path are matched to the same metrics.
+// Rules never matched, including optional rules and removed rules, have zero Count.
+func Coverage(rules []*Rule, metrics []*Metric) []*RuleCoverage {
+	m := makeRules(rules)
+	stats := make(map[*ruleMap]*valueStats)
+	for _, c := range metrics {
+		v := m.lookupPath(splitMetricName(c.Path))
+		if v == nil || !v.isLeaf() {
+			continue
+		}
+		v.used++
+		s, ok := stats[v]
+		if !ok {
+			s = &valueStats{}
+			stats[v] = s
+		}
+		s.add(c.Value)
+	}
+	results := make([]*RuleCoverage, len(rules))
+	for i, r := range rules {
+		c := &RuleCoverage{Rule: r, Min: math.NaN(), Max: math.NaN(), Mean: math.NaN()}
+		if v := m.lookupPath(splitMetricName(r.Path)); v != nil && v.isLeaf() && !r.Removed {
+			c.Count = v.used
+			if s := stats[v]; s != nil && s.n > 0 {
+				c.Min = s.min
+				c.Max = s.max
+				c.Mean = s.sum / float64(s.n)
+			}
+		}
+		if len(metrics) > 0 {
+			c.Fraction = float64(c.Count) / float64(len(metrics))
+		}
+		results[i] = c
+	}
+	return results
+}
+
+type valueStats struct {
+	n        int
+	min, max float64
+	sum      float64
+}
+
+func (s *valueStats) add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if s.n == 0 || v < s.min {
+		s.min = v
+	}
+	if s.n == 0 || v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	s.n++
+}
+
+func (s *valueStats) merge(t *valueStats) {
+	if t.n == 0 {
+		return
+	}
+	if s.n == 0 || t.min < s.min {
+		s.min = t.min
+	}
+	if s.n == 0 || t.max > s.max {
+		s.max = t.max
+	}
+	s.sum += t.sum
+	s.n += t.n
+}
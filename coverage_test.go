@@ -0,0 +1,70 @@
+package graphitemetrictest
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	in := "a.b >0\na.* <10\n~a.c\nb.#.c\nb.x\n"
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	metrics := []*Metric{
+		{Path: "a.b", Value: 1},
+		{Path: "a.b", Value: -2},
+		{Path: "a.x", Value: 1},
+		{Path: "b.y.c", Value: math.NaN()},
+		{Path: "z", Value: 1},
+	}
+	nan := math.NaN()
+	want := []struct {
+		count          int
+		fraction       float64
+		min, max, mean float64
+	}{
+		{count: 2, fraction: 0.4, min: -2, max: 1, mean: -0.5},
+		{count: 1, fraction: 0.2, min: 1, max: 1, mean: 1},
+		{count: 0, fraction: 0, min: nan, max: nan, mean: nan},
+		{count: 1, fraction: 0.2, min: nan, max: nan, mean: nan},
+		{count: 0, fraction: 0, min: nan, max: nan, mean: nan},
+	}
+	a := Coverage(rules, metrics)
+	if len(a) != len(want) {
+		t.Fatalf("Coverage(%v, %v) = %v; want %d items", rules, metrics, a, len(want))
+	}
+	for i, c := range a {
+		if c.Rule != rules[i] {
+			t.Errorf("Coverage(...)[%d].Rule = %v; want %v", i, c.Rule, rules[i])
+		}
+		if c.Count != want[i].count || c.Fraction != want[i].fraction {
+			t.Errorf("Coverage(...)[%d] = %d, %g; want %d, %g", i, c.Count, c.Fraction, want[i].count, want[i].fraction)
+		}
+		if !sameFloat(c.Min, want[i].min) || !sameFloat(c.Max, want[i].max) || !sameFloat(c.Mean, want[i].mean) {
+			t.Errorf("Coverage(...)[%d] = min %g, max %g, mean %g; want %g, %g, %g", i, c.Min, c.Max, c.Mean, want[i].min, want[i].max, want[i].mean)
+		}
+	}
+}
+
+func TestCoverage_empty(t *testing.T) {
+	rules := []*Rule{{Path: "a"}}
+	a := Coverage(rules, nil)
+	if len(a) != 1 || a[0].Count != 0 || a[0].Fraction != 0 {
+		t.Errorf("Coverage(%v, nil) = %v; want zero", rules, a)
+	}
+}
+
+func TestCoverage_removed(t *testing.T) {
+	rules := []*Rule{{Path: "a"}, {Path: "a", Removed: true}}
+	metrics := []*Metric{{Path: "a"}}
+	a := Coverage(rules, metrics)
+	if len(a) != 2 || a[0].Count != 1 || a[1].Count != 0 {
+		t.Errorf("Coverage(%v, %v) = %v; want counts [1 0]", rules, metrics, a)
+	}
+}
+
+func sameFloat(a, b float64) bool {
+	return a == b || math.IsNaN(a) && math.IsNaN(b)
+}
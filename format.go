@@ -0,0 +1,295 @@
+package graphitemetrictest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	errPath      = errors.New("a path must not be empty or contain white spaces")
+	errRulePath  = errors.New("a path must not start with a digit or a symbol of the rule syntax, contain braces, or end with ';'")
+	errRuleCount = errors.New("text must contain exactly one rule")
+)
+
+// MarshalText implements encoding.TextMarshaler.
+//
+// It returns a line of the plaintext protocol without trailing newline.
+// The value is formatted in the shortest representation that ReadMetrics reads back exactly.
+func (m *Metric) MarshalText() ([]byte, error) {
+	return appendMetric(nil, m)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *Metric) UnmarshalText(text []byte) error {
+	v, err := parseMetric(string(text))
+	if err != nil {
+		return err
+	}
+	*m = *v
+	return nil
+}
+
+func appendMetric(buf []byte, m *Metric) ([]byte, error) {
+	if m.Path == "" || strings.IndexFunc(m.Path, unicode.IsSpace) >= 0 {
+		return nil, errPath
+	}
+	buf = append(buf, m.Path...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendFloat(buf, m.Value, 'g', -1, 64)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, m.Timestamp, 10)
+	return buf, nil
+}
+
+// WriteMetrics writes metrics to w in the plaintext protocol.
+func WriteMetrics(w io.Writer, metrics []*Metric) error {
+	f := bufio.NewWriter(w)
+	var (
+		buf []byte
+		err error
+	)
+	for _, m := range metrics {
+		buf, err = appendMetric(buf[:0], m)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+		if _, err := f.Write(buf); err != nil {
+			return err
+		}
+	}
+	return f.Flush()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+//
+// It returns a line of the rule file without trailing newline.
+// Unlike String, the result can be read by ReadRules.
+func (r *Rule) MarshalText() ([]byte, error) {
+	return appendRule(nil, r)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (r *Rule) UnmarshalText(text []byte) error {
+	rules, err := ReadRules(bytes.NewReader(text))
+	if err != nil {
+		return err
+	}
+	if len(rules) != 1 {
+		return errRuleCount
+	}
+	*r = *rules[0]
+	return nil
+}
+
+func appendRule(buf []byte, r *Rule) ([]byte, error) {
+	if r.Removed {
+		// other fields are meaningless for the removal.
+		if err := checkRulePath(r.Path); err != nil {
+			return nil, err
+		}
+		return append(append(buf, '-'), r.Path...), nil
+	}
+	buf = appendSeverity(buf, r.Severity, SeverityError)
+	if c := r.When; c != nil {
+		if err := checkRulePath(c.Path); err != nil {
+			return nil, err
+		}
+		var err error
+		buf = append(buf, "when "...)
+		if buf, err = appendPathExprs(buf, c.Path, c.Exprs, ' '); err != nil {
+			return nil, err
+		}
+		buf = append(buf, " require "...)
+	}
+	if err := checkRulePath(r.Path); err != nil {
+		return nil, err
+	}
+	if !r.Required {
+		buf = append(buf, '~')
+	}
+	path := r.Path
+	if len(r.Labels) > 0 {
+		for _, s := range r.Labels {
+			if !isLabel(s) {
+				return nil, fmt.Errorf("cannot write the label %q", s)
+			}
+		}
+		path += " [" + strings.Join(r.Labels, ",") + "]"
+	}
+	return appendPathExprs(buf, path, r.Exprs, '\t')
+}
+
+// appendAnnotations appends comment lines of the description, the owner and the links of r.
+func appendAnnotations(buf []byte, r *Rule, indent string) []byte {
+	add := func(key, value string) {
+		for _, s := range strings.Split(value, "\n") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			buf = append(buf, indent...)
+			buf = append(buf, "// @"+key+" "...)
+			buf = append(buf, s...)
+			buf = append(buf, '\n')
+		}
+	}
+	add("desc", r.Description)
+	add("owner", r.Owner)
+	for _, s := range r.Links {
+		add("link", s)
+	}
+	return buf
+}
+
+// appendSeverity appends the annotation of s unless s is same as the inherited severity.
+func appendSeverity(buf []byte, s, inherited Severity) []byte {
+	if s == inherited {
+		return buf
+	}
+	buf = append(buf, '@')
+	buf = append(buf, s.String()...)
+	return append(buf, ' ')
+}
+
+func checkRulePath(s string) error {
+	if s == "" || strings.IndexFunc(s, unicode.IsSpace) >= 0 {
+		return errPath
+	}
+	if c := rune(s[0]); isNumber(c) || strings.ContainsRune("~<>,/+-|()[]$\"@=", c) {
+		return errRulePath
+	}
+	// ';' at the end of the path separates statements, and '[' starts labels.
+	if strings.ContainsAny(s, "{}[") || strings.HasSuffix(s, ";") {
+		return errRulePath
+	}
+	return nil
+}
+
+func appendPathExprs(buf []byte, path string, exprs []*Expr, sep byte) ([]byte, error) {
+	buf = append(buf, path...)
+	if len(exprs) == 0 {
+		return buf, nil
+	}
+	buf = append(buf, sep)
+	if len(exprs) == 1 && exprs[0].Op == Or {
+		// The sole alternatives don't need parentheses.
+		return appendAlts(buf, exprs[0].Alts)
+	}
+	return appendExprs(buf, exprs)
+}
+
+func appendExprs(buf []byte, exprs []*Expr) ([]byte, error) {
+	var err error
+	for i, e := range exprs {
+		if i > 0 {
+			buf = append(buf, ", "...)
+		}
+		if e.Op == Or {
+			buf = append(buf, '(')
+			if buf, err = appendAlts(buf, e.Alts); err != nil {
+				return nil, err
+			}
+			buf = append(buf, ')')
+			continue
+		}
+		if e.Op.isAssertion() {
+			buf = append(buf, e.Op.String()...)
+			continue
+		}
+		if math.IsNaN(e.Value) || math.IsInf(e.Value, 0) {
+			return nil, fmt.Errorf("cannot write %v in a rule", e.Value)
+		}
+		if e.X != nil {
+			buf = append(buf, e.X.String()...)
+			buf = append(buf, ' ')
+		}
+		buf = append(buf, e.Op.String()...)
+		buf = strconv.AppendFloat(buf, e.Value, 'f', -1, 64)
+	}
+	return buf, nil
+}
+
+func appendAlts(buf []byte, alts [][]*Expr) ([]byte, error) {
+	if len(alts) == 0 {
+		return nil, errors.New("cannot write empty alternatives in a rule")
+	}
+	var err error
+	for i, exprs := range alts {
+		if i > 0 {
+			buf = append(buf, " | "...)
+		}
+		if len(exprs) == 0 {
+			return nil, errors.New("cannot write an empty alternative in a rule")
+		}
+		if buf, err = appendExprs(buf, exprs); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// WriteRules writes rules to w in the syntax that ReadRules reads.
+func WriteRules(w io.Writer, rules []*Rule) error {
+	f := bufio.NewWriter(w)
+	var (
+		buf []byte
+		err error
+	)
+	written := make(map[*Group]bool)
+	for _, r := range rules {
+		if g := r.Group; g != nil {
+			if written[g] {
+				continue
+			}
+			written[g] = true
+			buf, err = appendGroup(buf[:0], g)
+		} else {
+			buf = appendAnnotations(buf[:0], r, "")
+			buf, err = appendRule(buf, r)
+			buf = append(buf, '\n')
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(buf); err != nil {
+			return err
+		}
+	}
+	return f.Flush()
+}
+
+func appendGroup(buf []byte, g *Group) ([]byte, error) {
+	buf = appendSeverity(buf, g.Severity, SeverityError)
+	switch {
+	case g.Min == 1 && g.Max == 1:
+		buf = append(buf, "oneof {\n"...)
+	case g.Min > 0 && g.Max == 0:
+		buf = append(buf, "atleast "...)
+		buf = strconv.AppendInt(buf, int64(g.Min), 10)
+		buf = append(buf, " {\n"...)
+	default:
+		return nil, fmt.Errorf("cannot write the group %v", g)
+	}
+	var err error
+	for _, r := range g.Rules {
+		// rules in the group are not required individually.
+		c := *r
+		c.Required = true
+		c.Severity = SeverityError
+		buf = appendAnnotations(buf, r, "\t")
+		buf = append(buf, '\t')
+		buf = appendSeverity(buf, r.Severity, g.Severity)
+		if buf, err = appendRule(buf, &c); err != nil {
+			return nil, err
+		}
+		buf = append(buf, '\n')
+	}
+	return append(buf, "}\n"...), nil
+}
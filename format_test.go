@@ -0,0 +1,267 @@
+package graphitemetrictest
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	metrics := []*Metric{
+		{Path: "a.b.c", Value: 0.0, Timestamp: 1623988183},
+		{Path: "a.b.c", Value: 0.1, Timestamp: -1},
+		{Path: "a.b.c", Value: -3.25e-10, Timestamp: 1623988183},
+		{Path: "a.b.c", Value: 1.2e+19, Timestamp: 1623988183},
+		{Path: "a.b.c", Value: math.MaxFloat64, Timestamp: 1623988183},
+		{Path: "a.b.c", Value: math.Inf(-1), Timestamp: 1623988183},
+		{Path: "a.b.c;host=x;dc=y", Value: 9007199254740993, Timestamp: 1623988183},
+	}
+	want := `a.b.c 0 1623988183
+a.b.c 0.1 -1
+a.b.c -3.25e-10 1623988183
+a.b.c 1.2e+19 1623988183
+a.b.c 1.7976931348623157e+308 1623988183
+a.b.c -Inf 1623988183
+a.b.c;host=x;dc=y 9.007199254740992e+15 1623988183
+`
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, metrics); err != nil {
+		t.Fatalf("WriteMetrics: %v", err)
+	}
+	if s := buf.String(); s != want {
+		t.Errorf("WriteMetrics = %q; want %q", s, want)
+	}
+
+	a, err := ReadMetrics(&buf)
+	if err != nil {
+		t.Fatalf("ReadMetrics: %v", err)
+	}
+	if !reflect.DeepEqual(a, metrics) {
+		t.Errorf("ReadMetrics(WriteMetrics(%v)) = %v", metrics, a)
+	}
+}
+
+func TestWriteMetrics_error(t *testing.T) {
+	tests := []string{
+		"",
+		"a.b c",
+		"a.b\n",
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := WriteMetrics(&buf, []*Metric{{Path: tt}})
+		if err == nil {
+			t.Errorf("WriteMetrics(%q) should return an error", tt)
+		}
+	}
+}
+
+func TestMetric_MarshalText(t *testing.T) {
+	m := &Metric{Path: "a.b.c", Value: 1.5, Timestamp: 1623988183}
+	b, err := m.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+	if s, want := string(b), "a.b.c 1.5 1623988183"; s != want {
+		t.Errorf("MarshalText() = %q; want %q", s, want)
+	}
+	var m1 Metric
+	if err := m1.UnmarshalText(b); err != nil {
+		t.Fatalf("UnmarshalText(%q): %v", b, err)
+	}
+	if !reflect.DeepEqual(&m1, m) {
+		t.Errorf("UnmarshalText(%q) = %v; want %v", b, &m1, m)
+	}
+	if err := m1.UnmarshalText([]byte("a.b.c 1.5")); err == nil {
+		t.Errorf("UnmarshalText should return an error")
+	}
+}
+
+func TestWriteRules(t *testing.T) {
+	rules := []*Rule{
+		{
+			Required: true,
+			Path:     "a.b.c",
+		},
+		{
+			Path: "a.#.c",
+			Exprs: []*Expr{
+				{Op: GreaterThan, Value: 0},
+				{Op: LessEqual, Value: 6},
+			},
+		},
+		{
+			Required: true,
+			Path:     "a.b.*",
+			Exprs: []*Expr{
+				{Op: GreaterEqual, Value: -0.25},
+				{Op: LessThan, Value: 1e21},
+				{Op: GreaterThan, Value: 1e-7},
+			},
+		},
+		{
+			Required: true,
+			Path:     "a.b.d",
+			Exprs: []*Expr{
+				{Op: Or, Alts: [][]*Expr{
+					{{Op: LessThan, Value: 0}},
+					{{Op: GreaterEqual, Value: 3}, {Op: LessThan, Value: 5}},
+				}},
+			},
+		},
+		{
+			Required: true,
+			Path:     "a.b.e",
+			Exprs: []*Expr{
+				{Op: GreaterEqual, Value: -1},
+				{Op: Or, Alts: [][]*Expr{
+					{{Op: LessThan, Value: 0}},
+					{{Op: GreaterEqual, Value: 3}},
+				}},
+			},
+		},
+		{
+			Required: true,
+			Path:     "a.b.f",
+			Exprs: []*Expr{
+				{Op: LessThan, Value: 100, X: &binaryValue{op: "/", x: valueRef{}, y: constValue(1024)}},
+				{Op: Equal, Value: 0, X: &binaryValue{op: "%", x: valueRef{}, y: constValue(512)}},
+			},
+		},
+		{
+			Required: true,
+			Path:     "a.b.g",
+			Exprs: []*Expr{
+				{Op: Integer},
+				{Op: Finite},
+				{Op: GreaterEqual, Value: 0},
+			},
+		},
+		{
+			Required: true,
+			Path:     "a.b.lag",
+			Exprs:    []*Expr{{Op: GreaterEqual, Value: 0}},
+			When: &Condition{
+				Path:  "a.b.role",
+				Exprs: []*Expr{{Op: Equal, Value: 1}},
+			},
+		},
+	}
+	rules = append(rules, OneOf(
+		&Rule{Path: "a.ipv4", Exprs: []*Expr{{Op: GreaterEqual, Value: 0}}},
+		&Rule{Path: "a.ipv6"},
+	).Rules...)
+	rules = append(rules, &Rule{Required: true, Path: "a.b.h", Severity: SeverityWarning})
+	rules = append(rules, &Rule{
+		Required:    true,
+		Path:        "a.b.i",
+		Description: "first\nsecond",
+		Owner:       "team",
+		Links:       []string{"https://example.com/a"},
+	})
+	rules = append(rules, &Rule{Required: true, Path: "a.b.k", Removed: true})
+	rules = append(rules, &Rule{
+		Required: true,
+		Path:     "a.b.j",
+		Labels:   []string{"linux", "mysql8"},
+		Exprs:    []*Expr{{Op: GreaterThan, Value: 0}},
+	})
+	rules = append(rules, &Rule{
+		Required: true,
+		Path:     "a.b.t;tag=x;dc=y",
+		Exprs:    []*Expr{{Op: GreaterThan, Value: 0}},
+	})
+	g := AtLeast(1, &Rule{Path: "a.x", Severity: SeverityInfo}, &Rule{Path: "a.y"})
+	g.Severity = SeverityInfo
+	rules = append(rules, g.Rules...)
+	want := "a.b.c\n" +
+		"~a.#.c\t>0, <=6\n" +
+		"a.b.*\t>=-0.25, <1000000000000000000000, >0.0000001\n" +
+		"a.b.d\t<0 | >=3, <5\n" +
+		"a.b.e\t>=-1, (<0 | >=3)\n" +
+		"a.b.f\tvalue/1024 <100, value % 512 ==0\n" +
+		"a.b.g\tint, finite, >=0\n" +
+		"when a.b.role ==1 require a.b.lag\t>=0\n" +
+		"oneof {\n\ta.ipv4\t>=0\n\ta.ipv6\n}\n" +
+		"@warning a.b.h\n" +
+		"// @desc first\n// @desc second\n// @owner team\n// @link https://example.com/a\na.b.i\n" +
+		"-a.b.k\n" +
+		"a.b.j [linux,mysql8]\t>0\n" +
+		"a.b.t;tag=x;dc=y\t>0\n" +
+		"@info atleast 1 {\n\ta.x\n\t@error a.y\n}\n"
+	var buf bytes.Buffer
+	if err := WriteRules(&buf, rules); err != nil {
+		t.Fatalf("WriteRules: %v", err)
+	}
+	if s := buf.String(); s != want {
+		t.Errorf("WriteRules = %q; want %q", s, want)
+	}
+
+	a, err := ReadRules(&buf)
+	if err != nil {
+		t.Fatalf("ReadRules: %v", err)
+	}
+	if !reflect.DeepEqual(a, rules) {
+		t.Errorf("ReadRules(WriteRules(%v)) = %v", rules, a)
+	}
+}
+
+func TestWriteRules_error(t *testing.T) {
+	tests := []*Rule{
+		{Path: ""},
+		{Path: "a b"},
+		{Path: "~a"},
+		{Path: "//a"},
+		{Path: "1.a"},
+		{Path: "-a"},
+		{Path: "(a)"},
+		{Path: "[a]"},
+		{Path: "$a"},
+		{Path: "\"a\""},
+		{Path: "@a"},
+		{Path: "|a"},
+		{Path: "=a"},
+		{Path: "a{b"},
+		{Path: "a.b}"},
+		{Path: "a.b;"},
+		{Path: "a.b[linux]"},
+		{Path: "a", When: &Condition{Path: "b;"}},
+		{Path: "a", Labels: []string{"8x"}},
+		{Path: "a", Exprs: []*Expr{{Op: Or}}},
+		{Path: "a", Exprs: []*Expr{{Op: LessThan, Value: math.NaN()}}},
+		{Path: "a", Exprs: []*Expr{{Op: LessThan, Value: math.Inf(1)}}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteRules(&buf, []*Rule{tt}); err == nil {
+			t.Errorf("WriteRules(%v) should return an error", tt)
+		}
+	}
+}
+
+func TestRule_MarshalText(t *testing.T) {
+	r := &Rule{
+		Path: "a.b.c",
+		Exprs: []*Expr{
+			{Op: GreaterThan, Value: -1},
+		},
+	}
+	b, err := r.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+	if s, want := string(b), "~a.b.c\t>-1"; s != want {
+		t.Errorf("MarshalText() = %q; want %q", s, want)
+	}
+	var r1 Rule
+	if err := r1.UnmarshalText(b); err != nil {
+		t.Fatalf("UnmarshalText(%q): %v", b, err)
+	}
+	if !reflect.DeepEqual(&r1, r) {
+		t.Errorf("UnmarshalText(%q) = %v; want %v", b, &r1, r)
+	}
+	if err := r1.UnmarshalText([]byte("a.b.c\na.b.d")); err == nil {
+		t.Errorf("UnmarshalText should return an error")
+	}
+}
@@ -0,0 +1,225 @@
+package graphitemetrictest
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// InferOptions represents the heuristic of InferRules.
+type InferOptions struct {
+	// IsVariant reports whether the segment s varies between hosts, such as CPU indices.
+	// Segments that IsVariant reports are always collapsed into '#'.
+	// If IsVariant is nil, segments consisted of only digits are variants.
+	IsVariant func(s string) bool
+
+	// Other segments are collapsed into '#' if at least MinVariants siblings have the same sub paths,
+	// such as "sda" and "sdb" in "disks.sda.reads" and "disks.sdb.reads".
+	// Siblings that have no sub paths are never collapsed by this. If MinVariants is zero, it is disabled.
+	MinVariants int
+
+	// Headroom is the fraction of the observed range added to both ends of the range.
+	// The range does not contain negative values if all observed values are not negative.
+	Headroom float64
+}
+
+// DefaultInferOptions is the options that InferRules uses when it is given nil.
+var DefaultInferOptions = &InferOptions{
+	MinVariants: 2,
+	Headroom:    0.1,
+}
+
+// InferRules returns required rules that metrics satisfy.
+//
+// Paths of metrics are collapsed by the heuristic of opts, and the range of each rules is
+// the range between the minimum and the maximum of the values with the headroom.
+// The range is rounded outward to the second most significant digit of its width.
+// The rules are sorted by their path.
+func InferRules(metrics []*Metric, opts *InferOptions) []*Rule {
+	if opts == nil {
+		opts = DefaultInferOptions
+	}
+	root := &inferNode{}
+	for _, m := range metrics {
+		path, _, _ := strings.Cut(m.Path, ";")
+		root.add(strings.Split(path, "."), m.Value)
+	}
+	root.collapse(opts)
+
+	var rules []*Rule
+	root.walk(nil, func(p []string, s *valueStats) {
+		rules = append(rules, &Rule{
+			Required: true,
+			Path:     strings.Join(p, "."),
+			Exprs:    inferExprs(s, opts.Headroom),
+		})
+	})
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Path < rules[j].Path
+	})
+	return rules
+}
+
+// inferNode is a node of the tree of metric paths.
+// The node is a leaf if stats is not nil; the node can also have children.
+type inferNode struct {
+	children map[string]*inferNode
+	stats    *valueStats
+}
+
+func (n *inferNode) add(p []string, value float64) {
+	for _, s := range p {
+		if n.children == nil {
+			n.children = make(map[string]*inferNode)
+		}
+		c, ok := n.children[s]
+		if !ok {
+			c = &inferNode{}
+			n.children[s] = c
+		}
+		n = c
+	}
+	if n.stats == nil {
+		n.stats = &valueStats{}
+	}
+	n.stats.add(value)
+}
+
+// collapse collapses variant children into '#' from leaves to the root.
+func (n *inferNode) collapse(opts *InferOptions) {
+	if len(n.children) == 0 {
+		return
+	}
+	for _, c := range n.children {
+		c.collapse(opts)
+	}
+	if !n.isVariant(opts) {
+		return
+	}
+	v := &inferNode{}
+	for _, c := range n.children {
+		v.merge(c)
+	}
+	n.children = map[string]*inferNode{"#": v}
+}
+
+func (n *inferNode) isVariant(opts *InferOptions) bool {
+	isVariant := opts.IsVariant
+	if isVariant == nil {
+		isVariant = isDigits
+	}
+	all := true
+	for s := range n.children {
+		if !isVariant(s) {
+			all = false
+			break
+		}
+	}
+	if all {
+		return true
+	}
+	if opts.MinVariants <= 0 || len(n.children) < opts.MinVariants {
+		return false
+	}
+	shape := ""
+	for _, c := range n.children {
+		if len(c.children) == 0 {
+			return false
+		}
+		s := c.shape()
+		if shape == "" {
+			shape = s
+		} else if s != shape {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, isNotDigit) < 0
+}
+
+// shape returns the representation of sub paths of n.
+func (n *inferNode) shape() string {
+	var a []string
+	n.walk(nil, func(p []string, s *valueStats) {
+		a = append(a, strings.Join(p, "."))
+	})
+	sort.Strings(a)
+	return strings.Join(a, "\n")
+}
+
+func (n *inferNode) merge(c *inferNode) {
+	if c.stats != nil {
+		if n.stats == nil {
+			n.stats = &valueStats{}
+		}
+		n.stats.merge(c.stats)
+	}
+	for s, v := range c.children {
+		if n.children == nil {
+			n.children = make(map[string]*inferNode)
+		}
+		w, ok := n.children[s]
+		if !ok {
+			w = &inferNode{}
+			n.children[s] = w
+		}
+		w.merge(v)
+	}
+}
+
+func (n *inferNode) walk(p []string, f func(p []string, s *valueStats)) {
+	if n.stats != nil {
+		f(p, n.stats)
+	}
+	for s, c := range n.children {
+		c.walk(append(p[:len(p):len(p)], s), f)
+	}
+}
+
+func inferExprs(s *valueStats, headroom float64) []*Expr {
+	if s.n == 0 {
+		return nil
+	}
+	d := (s.max - s.min) * headroom
+	if d == 0 {
+		d = math.Abs(s.max) * headroom
+	}
+	low := s.min - d
+	high := s.max + d
+	if s.min >= 0 && low < 0 {
+		low = 0
+	}
+	if math.IsInf(low, 0) || math.IsInf(high, 0) {
+		return nil
+	}
+	if w := high - low; w > 0 {
+		exp := int(math.Floor(math.Log10(w))) - 1
+		low = roundTo(low, exp, math.Floor)
+		high = roundTo(high, exp, math.Ceil)
+	}
+	return []*Expr{
+		{Op: GreaterEqual, Value: low},
+		{Op: LessEqual, Value: high},
+	}
+}
+
+// roundTo rounds v to a multiple of 10^exp with f.
+func roundTo(v float64, exp int, f func(float64) float64) float64 {
+	if exp < 0 {
+		p := math.Pow10(-exp)
+		return f(snap(v*p)) / p
+	}
+	p := math.Pow10(exp)
+	return f(snap(v/p)) * p
+}
+
+// snap returns the nearest integer if x is close enough to it; such as 110.00000000000001 from 1.1*100.
+func snap(x float64) float64 {
+	if r := math.Round(x); math.Abs(x-r) < 1e-9*math.Max(1, math.Abs(x)) {
+		return r
+	}
+	return x
+}
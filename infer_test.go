@@ -0,0 +1,91 @@
+package graphitemetrictest
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInferRules(t *testing.T) {
+	in := `custom.disks.sda.reads 100 1
+custom.disks.sda.writes 5 1
+custom.disks.sdb.reads 2340 1
+custom.disks.sdb.writes 7 1
+custom.cpu.0.user 12.5 1
+custom.cpu.1.user 80.2 1
+custom.mysql.connections 42 1
+custom.mysql.threads 8 1
+custom.temp -3.5 1
+custom.temp 0.25 1
+custom.zero 0 1
+`
+	metrics, err := ReadMetrics(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadMetrics: %v", err)
+	}
+	want := "custom.cpu.#.user\t>=5, <=87\n" +
+		"custom.disks.#.reads\t>=0, <=2600\n" +
+		"custom.disks.#.writes\t>=4.8, <=7.2\n" +
+		"custom.mysql.connections\t>=37.8, <=46.2\n" +
+		"custom.mysql.threads\t>=7.2, <=8.8\n" +
+		"custom.temp\t>=-3.9, <=0.7\n" +
+		"custom.zero\t>=0, <=0\n"
+	var b strings.Builder
+	if err := WriteRules(&b, InferRules(metrics, nil)); err != nil {
+		t.Fatalf("WriteRules: %v", err)
+	}
+	if s := b.String(); s != want {
+		t.Errorf("InferRules = %q; want %q", s, want)
+	}
+}
+
+func TestInferRules_options(t *testing.T) {
+	metrics := []*Metric{
+		{Path: "a.eth0.rx", Value: 1},
+		{Path: "a.eth1.rx", Value: 2},
+		{Path: "a.lo.rx", Value: 3},
+		{Path: "b.x1", Value: 1},
+		{Path: "b.x2", Value: 1},
+		{Path: "c.y", Value: math.NaN()},
+	}
+	tests := []struct {
+		name string
+		opts *InferOptions
+		want []string
+	}{
+		{
+			name: "disabled",
+			opts: &InferOptions{},
+			want: []string{"a.eth0.rx[>=1,<=1]", "a.eth1.rx[>=2,<=2]", "a.lo.rx[>=3,<=3]", "b.x1[>=1,<=1]", "b.x2[>=1,<=1]", "c.y[]"},
+		},
+		{
+			name: "variants",
+			opts: &InferOptions{MinVariants: 4},
+			want: []string{"a.eth0.rx[>=1,<=1]", "a.eth1.rx[>=2,<=2]", "a.lo.rx[>=3,<=3]", "b.x1[>=1,<=1]", "b.x2[>=1,<=1]", "c.y[]"},
+		},
+		{
+			name: "custom",
+			opts: &InferOptions{
+				IsVariant: func(s string) bool { return strings.HasPrefix(s, "x") },
+				Headroom:  0.5,
+			},
+			want: []string{"a.eth0.rx[>=0.5,<=1.5]", "a.eth1.rx[>=1,<=3]", "a.lo.rx[>=1.5,<=4.5]", "b.#[>=0.5,<=1.5]", "c.y[]"},
+		},
+		{
+			name: "default",
+			want: []string{"a.#.rx[>=0.8,<=3.2]", "b.x1[>=0.9,<=1.1]", "b.x2[>=0.9,<=1.1]", "c.y[]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a []string
+			for _, r := range InferRules(metrics, tt.opts) {
+				a = append(a, r.String())
+			}
+			if !reflect.DeepEqual(a, tt.want) {
+				t.Errorf("InferRules = %q; want %q", a, tt.want)
+			}
+		})
+	}
+}
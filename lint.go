@@ -0,0 +1,252 @@
+package graphitemetrictest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+var (
+	errEmptyRange = errors.New("expressions are never satisfied")
+	errDuplicate  = errors.New("duplicate rule")
+	errShadowed   = errors.New("shadowed")
+	errInterior   = errors.New("never matched")
+	errDeadEnd    = errors.New("not matched")
+	errRedundant  = errors.New("redundant")
+)
+
+// LintError represents a problem of the rule found by Lint.
+type LintError struct {
+	Rule  *Rule
+	Other *Rule // the rule that causes the problem; it is nil if the problem is in Rule itself.
+	Err   error
+}
+
+// Error returns a string representation of an error.
+func (e *LintError) Error() string {
+	return fmt.Sprintf("rule %v: %v", e.Rule, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LintError) Unwrap() error { return e.Err }
+
+// Lint checks rules and returns problems that Diff does not report:
+//
+//   - expressions that are never satisfied such as ">5, <3".
+//   - duplicate rules.
+//   - rules shadowed by other rules; Diff checks a metric with the rule
+//     that has an exact segment rather than '*' at the first different segment.
+//   - rules never matched because other rules are under their paths.
+//   - wildcard rules that don't match some metrics because of exact segments of other rules.
+//   - required rules made redundant by optional rules of the same path and labels.
+//
+// Removed rules are ignored.
+func Lint(rules []*Rule) []*LintError {
+	rules = dropRemoved(rules)
+	var results []*LintError
+	report := func(r, other *Rule, err error) {
+		results = append(results, &LintError{Rule: r, Other: other, Err: err})
+	}
+
+	m := makeRules(rules)
+	paths := make([][]string, len(rules))
+	for i, r := range rules {
+		paths[i] = splitMetricName(r.Path)
+	}
+	for i, r := range rules {
+		if !isSatisfiable(r.Exprs) {
+			report(r, nil, errEmptyRange)
+		}
+		for j := 0; j < i; j++ {
+			if ruleKey(rules[j]) == ruleKey(r) {
+				report(r, rules[j], fmt.Errorf("%w of rule %v", errDuplicate, rules[j]))
+				break
+			}
+		}
+		if v := m.lookupPath(paths[i]); v != nil && !v.isLeaf() {
+			key := strings.Join(paths[i], ".")
+			for j, p := range paths {
+				if len(p) > len(paths[i]) && strings.Join(p[:len(paths[i])], ".") == key {
+					report(r, rules[j], fmt.Errorf("%w because rule %v is under the path", errInterior, rules[j]))
+					break
+				}
+			}
+		}
+	}
+	shadowed := make(map[string]bool) // pairs of the rule and the path.
+	for i, a := range rules {
+		for j := i + 1; j < len(rules); j++ {
+			b := rules[j]
+			p, ok := overlapPath(paths[i], paths[j])
+			if !ok || pathKey(a.Path) == pathKey(b.Path) {
+				continue
+			}
+			v := m.lookupPath(p)
+			if v == nil || !v.isLeaf() {
+				continue
+			}
+			s := strings.Join(p, ".")
+			for _, r := range []*Rule{a, b} {
+				key := fmt.Sprintf("%p\x00%s", r, s)
+				if v.has(r) || shadowed[key] {
+					continue
+				}
+				shadowed[key] = true
+				report(r, v.rules[0], fmt.Errorf("%w by rule %v for %s", errShadowed, v.rules[0], s))
+			}
+		}
+	}
+	for i, r := range rules {
+		for _, p := range deadEnds(m, paths[i]) {
+			report(r, nil, fmt.Errorf("%w to %s because of exact segments of other rules", errDeadEnd, strings.Join(p, ".")))
+		}
+	}
+	for _, r := range rules {
+		if !r.Required || r.When != nil || r.Group != nil {
+			continue
+		}
+		for _, o := range rules {
+			if !o.Required && o.Group == nil && pathKey(o.Path) == pathKey(r.Path) && labelKey(o.Labels) == labelKey(r.Labels) {
+				report(r, o, fmt.Errorf("%w because of optional rule %v of the same path", errRedundant, o))
+				break
+			}
+		}
+	}
+	return results
+}
+
+// ruleKey returns the string that is same between duplicate rules.
+// Rules are not duplicates if their labels, severities or groups are different.
+func ruleKey(r *Rule) string {
+	c := *r
+	c.Path = pathKey(r.Path)
+	return fmt.Sprintf("%v %s %v %p", &c, labelKey(r.Labels), r.Severity, r.Group)
+}
+
+// labelKey returns the string that is same between the same set of labels.
+func labelKey(labels []string) string {
+	a := append([]string(nil), labels...)
+	sort.Strings(a)
+	return strings.Join(a, ",")
+}
+
+func (m *ruleMap) has(r *Rule) bool {
+	for _, v := range m.rules {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapPath returns the path that matches to metrics matched to both a and b.
+func overlapPath(a, b []string) ([]string, bool) {
+	if len(a) != len(b) {
+		return nil, false
+	}
+	p := make([]string, len(a))
+	for i := range a {
+		switch {
+		case a[i] == b[i], b[i] == anyChar:
+			p[i] = a[i]
+		case a[i] == anyChar:
+			p[i] = b[i]
+		default:
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+// deadEnds returns paths that are matched to the wildcard path p but are not matched to any rules,
+// because Diff follows exact segments of other rules instead of '*' of p.
+func deadEnds(m *ruleMap, p []string) [][]string {
+	var results [][]string
+	v := m
+	for i, s := range p {
+		if s == anyChar {
+			keys := make([]string, 0, len(v.tree))
+			for k := range v.tree {
+				if k != anyChar {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				probe := append([]string{}, p...)
+				probe[i] = k
+				if w := m.lookupPath(probe); w == nil || !w.isLeaf() {
+					results = append(results, probe)
+				}
+			}
+		}
+		v = v.tree[s]
+	}
+	return results
+}
+
+// isSatisfiable returns false if no values satisfy all of exprs.
+// It only checks comparisons of the metric value.
+func isSatisfiable(exprs []*Expr) bool {
+	var (
+		low, high         = math.Inf(-1), math.Inf(1)
+		lowOpen, highOpen bool
+		excluded          []float64
+	)
+	lower := func(v float64, open bool) {
+		if v > low || v == low && open {
+			low, lowOpen = v, open
+		}
+	}
+	upper := func(v float64, open bool) {
+		if v < high || v == high && open {
+			high, highOpen = v, open
+		}
+	}
+	for _, e := range exprs {
+		if e.X != nil {
+			continue
+		}
+		switch e.Op {
+		case GreaterThan:
+			lower(e.Value, true)
+		case GreaterEqual:
+			lower(e.Value, false)
+		case LessThan:
+			upper(e.Value, true)
+		case LessEqual:
+			upper(e.Value, false)
+		case Equal:
+			lower(e.Value, false)
+			upper(e.Value, false)
+		case NotEqual:
+			excluded = append(excluded, e.Value)
+		case NonNegative:
+			lower(0, false)
+		case Or:
+			ok := false
+			for _, alt := range e.Alts {
+				if isSatisfiable(alt) {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+	if low > high || low == high && (lowOpen || highOpen) {
+		return false
+	}
+	if low == high {
+		for _, v := range excluded {
+			if v == low {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package graphitemetrictest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		want  []string
+		other []string
+		err   error
+	}{
+		{
+			name: "empty",
+			in:   "a >5, <3\nb >=1, <=1\nc >1, <=1\nd ==1, !=1\ne >0 | <0\nf (>5, <3 | >9, <8)\ng nonneg, <0\n",
+			want: []string{"a[>5,<3]", "c[>1,<=1]", "d[==1,!=1]", "f[(>5,<3|>9,<8)]", "g[nonneg,<0]"},
+			err:  errEmptyRange,
+		},
+		{
+			name:  "duplicate",
+			in:    "a >0\na.# >0\na.* >=0\na.* >0\n~a.* >0\nb [y,x]\nb [x,y]\n",
+			want:  []string{"a.*[>0]", "b[]"},
+			other: []string{"a.#[>0]", "b[]"},
+			err:   errDuplicate,
+		},
+		{
+			name:  "shadowed",
+			in:    "a.*.c\na.b.c\na.b.*\nx.*\n",
+			want:  []string{"a.*.c[]", "a.b.*[]"},
+			other: []string{"a.b.c[]", "a.b.c[]"},
+			err:   errShadowed,
+		},
+		{
+			name:  "interior",
+			in:    "a.b\na.b.c\na.*\n",
+			want:  []string{"a.b[]"},
+			other: []string{"a.b.c[]"},
+			err:   errInterior,
+		},
+		{
+			name: "dead end",
+			in:   "a.*.d\na.b.c\n",
+			want: []string{"a.*.d[]"},
+			err:  errDeadEnd,
+		},
+		{
+			name:  "redundant",
+			in:    "a >0\n~a\nb\n~b.c\n",
+			want:  []string{"a[>0]"},
+			other: []string{"~a[]"},
+			err:   errRedundant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ReadRules(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("ReadRules(%q): %v", tt.in, err)
+			}
+			var a []*LintError
+			for _, e := range Lint(rules) {
+				if errors.Is(e, tt.err) {
+					a = append(a, e)
+				}
+			}
+			if len(a) != len(tt.want) {
+				t.Fatalf("Lint(%v) = %v; want %v", rules, a, tt.want)
+			}
+			for i, e := range a {
+				if s := e.Rule.String(); s != tt.want[i] {
+					t.Errorf("Lint(%v)[%d].Rule = %s; want %s", rules, i, s, tt.want[i])
+				}
+				if tt.other == nil {
+					continue
+				}
+				if s := e.Other.String(); s != tt.other[i] {
+					t.Errorf("Lint(%v)[%d].Other = %s; want %s", rules, i, s, tt.other[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLint_clean(t *testing.T) {
+	in := "a.b >0, <=6\na.c.*\n~a.d\nb.*.c 0..1\n-a.b\n" +
+		"x [linux] >0\nx [darwin] >0\n@warn y\ny\nz [linux]\n~z [darwin]\n"
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	if a := Lint(rules); len(a) != 0 {
+		t.Errorf("Lint(%v) = %v; want nothing", rules, a)
+	}
+}
@@ -0,0 +1,630 @@
+package graphitemetrictest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNoFS = errors.New("include directive is not allowed here; use ReadRulesFS")
+
+// ReadRulesFS reads rule files matched to pattern in fsys and returns rules.
+// The format of each files is determined by FormatOf.
+//
+// Include directives in the files are resolved relative to the including file within fsys;
+// rooted patterns such as "/etc/graphite/common.rules" are resolved from the root of fsys.
+// Variables and templates defined in the included file are available in the including file.
+// Each files are read at most once even if they are included multiple times,
+// but it returns an error if the files include each other recursively.
+func ReadRulesFS(fsys fs.FS, pattern string) ([]*Rule, error) {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no matching files", pattern)
+	}
+	l := loader{
+		fsys:   fsys,
+		loaded: make(map[string]*scope),
+	}
+	var rules []*Rule
+	for _, name := range names {
+		a, _, err := l.load(name, "")
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, a...)
+	}
+	return rules, nil
+}
+
+type loader struct {
+	fsys      fs.FS
+	loading   []string          // files being loaded, for detecting cycles.
+	loaded    map[string]*scope // pairs of the file and the prefix.
+	expanding []*TemplateBlock
+}
+
+// scope holds the prefix, variables and templates of the block.
+type scope struct {
+	parent    *scope
+	prefix    string
+	vars      map[string]*variable
+	templates map[string]*template
+}
+
+// variable is the value defined by the let statement.
+type variable struct {
+	value float64
+}
+
+type template struct {
+	block *TemplateBlock
+	sc    *scope // scope where the template is defined.
+	file  string // name of the file where the template is defined.
+}
+
+func (s *scope) child(prefix string) *scope {
+	return &scope{parent: s, prefix: joinPath(s.prefix, prefix)}
+}
+
+func (s *scope) lookup(name string) (float64, error) {
+	for p := s; p != nil; p = p.parent {
+		if v, ok := p.vars[name]; ok {
+			return v.value, nil
+		}
+	}
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return 0, fmt.Errorf("undefined variable $%s", name)
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable $%s is not a number: %w", name, err)
+	}
+	return n, nil
+}
+
+func (s *scope) define(name string, v float64) error {
+	if _, ok := s.vars[name]; ok {
+		return fmt.Errorf("variable $%s is already defined", name)
+	}
+	if s.vars == nil {
+		s.vars = make(map[string]*variable)
+	}
+	s.vars[name] = &variable{value: v}
+	return nil
+}
+
+func (s *scope) lookupTemplate(name string) (*template, error) {
+	for p := s; p != nil; p = p.parent {
+		if t, ok := p.templates[name]; ok {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("undefined template %s", name)
+}
+
+func (s *scope) defineTemplate(file string, block *TemplateBlock) error {
+	if _, ok := s.templates[block.Name]; ok {
+		return fmt.Errorf("template %s is already defined", block.Name)
+	}
+	if s.templates == nil {
+		s.templates = make(map[string]*template)
+	}
+	s.templates[block.Name] = &template{block: block, sc: s, file: file}
+	return nil
+}
+
+// merge adds variables and templates defined in t to s.
+// It returns an error if s already has another definition of the same name.
+func (s *scope) merge(t *scope) error {
+	names := make([]string, 0, len(t.vars))
+	for name := range t.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := t.vars[name]
+		if w, ok := s.vars[name]; ok {
+			if w != v {
+				return fmt.Errorf("variable $%s is already defined", name)
+			}
+			continue
+		}
+		if s.vars == nil {
+			s.vars = make(map[string]*variable)
+		}
+		s.vars[name] = v
+	}
+	names = names[:0]
+	for name := range t.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := t.templates[name]
+		if w, ok := s.templates[name]; ok {
+			if w != v {
+				return fmt.Errorf("template %s is already defined", name)
+			}
+			continue
+		}
+		if s.templates == nil {
+			s.templates = make(map[string]*template)
+		}
+		s.templates[name] = v
+	}
+	return nil
+}
+
+// load reads the file and returns rules and the scope that holds definitions in the file.
+// It returns no rules if the file is already loaded with the prefix.
+func (l *loader) load(name, prefix string) ([]*Rule, *scope, error) {
+	key := name + "\x00" + prefix
+	if sc, ok := l.loaded[key]; ok {
+		return nil, sc, nil
+	}
+	sc := &scope{prefix: prefix}
+	l.loaded[key] = sc
+
+	b, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format := FormatOf(name); format != TextFormat {
+		rules, err := format.ReadRules(bytes.NewReader(b))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, r := range rules {
+			r.Path = joinPath(prefix, r.Path)
+			if r.When != nil {
+				r.When.Path = joinPath(prefix, r.When.Path)
+			}
+		}
+		return rules, sc, nil
+	}
+	f, err := ParseFile(name, bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	l.loading = append(l.loading, name)
+	defer func() {
+		l.loading = l.loading[:len(l.loading)-1]
+	}()
+	rules, err := l.eval(f, sc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rules, sc, nil
+}
+
+func (l *loader) eval(f *File, sc *scope) ([]*Rule, error) {
+	rules, err := l.evalStmts(f.Name, f.Stmts, sc)
+	if err != nil {
+		var e *ParseError
+		if errors.As(err, &e) {
+			return nil, err
+		}
+		return nil, &ParseError{File: f.Name, Line: errorLine(err), Err: err}
+	}
+	return rules, nil
+}
+
+// posError is an error at the position in the file.
+type posError struct {
+	pos Pos
+	err error
+}
+
+func (e *posError) Error() string { return e.err.Error() }
+func (e *posError) Unwrap() error { return e.err }
+
+func errorLine(err error) int {
+	var e *posError
+	if errors.As(err, &e) {
+		return e.pos.Line
+	}
+	return 0
+}
+
+func (l *loader) evalStmts(name string, stmts []Stmt, sc *scope) ([]*Rule, error) {
+	var (
+		rules []*Rule
+		ann   annotation
+	)
+	for _, stmt := range stmts {
+		if c, ok := stmt.(*Comment); ok {
+			ann.add(c)
+			continue
+		}
+		switch s := stmt.(type) {
+		case *RuleStmt:
+			r, err := evalRule(s, sc)
+			if err != nil {
+				return nil, err
+			}
+			ann.apply(r)
+			rules = append(rules, r)
+		case *WhenStmt:
+			r, err := evalWhen(s, sc)
+			if err != nil {
+				return nil, err
+			}
+			ann.apply(r)
+			rules = append(rules, r)
+		case *IncludeStmt:
+			a, err := l.include(name, s, sc)
+			if err != nil {
+				var e *ParseError
+				if errors.As(err, &e) {
+					return nil, err
+				}
+				return nil, &posError{s.Start, err}
+			}
+			rules = append(rules, a...)
+		case *PrefixBlock:
+			a, err := l.evalStmts(name, s.Stmts, sc.child(s.Prefix))
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, a...)
+		case *GroupBlock:
+			a, err := evalGroup(s, sc)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range a {
+				ann.apply(r)
+			}
+			rules = append(rules, a...)
+		case *TemplateBlock:
+			if err := sc.defineTemplate(name, s); err != nil {
+				return nil, &posError{s.Start, err}
+			}
+		case *UseStmt:
+			a, err := l.use(name, s, sc)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, a...)
+		case *LetStmt:
+			v, err := evalNumber(s.Value, sc)
+			if err != nil {
+				return nil, err
+			}
+			if err := sc.define(s.Name, v); err != nil {
+				return nil, &posError{s.Start, err}
+			}
+		}
+		ann = annotation{}
+	}
+	return rules, nil
+}
+
+// annotation is metadata of the rule written in comment lines just before the rule,
+// such as "// @desc text", "// @owner name" and "// @link url".
+// Annotations before the group are applied to rules in the group that don't have their own.
+type annotation struct {
+	desc   []string
+	owners []string
+	links  []string
+}
+
+// add adds the annotation in c; it ignores c if c is not an annotation.
+// The description and the owner can be written in multiple lines.
+func (a *annotation) add(c *Comment) {
+	s := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+	if !strings.HasPrefix(s, "@") {
+		return
+	}
+	key, value, _ := strings.Cut(s[1:], " ")
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	switch key {
+	case "desc":
+		a.desc = append(a.desc, value)
+	case "owner":
+		a.owners = append(a.owners, value)
+	case "link":
+		a.links = append(a.links, value)
+	}
+}
+
+func (a *annotation) apply(r *Rule) {
+	if r.Description == "" {
+		r.Description = strings.Join(a.desc, "\n")
+	}
+	if r.Owner == "" {
+		r.Owner = strings.Join(a.owners, ", ")
+	}
+	if r.Links == nil {
+		r.Links = a.links
+	}
+}
+
+func (l *loader) use(name string, s *UseStmt, sc *scope) ([]*Rule, error) {
+	t, err := sc.lookupTemplate(s.Name)
+	if err != nil {
+		return nil, &posError{s.Start, err}
+	}
+	for _, b := range l.expanding {
+		if b == t.block {
+			return nil, &posError{s.Start, fmt.Errorf("template %s uses itself", s.Name)}
+		}
+	}
+	l.expanding = append(l.expanding, t.block)
+	defer func() {
+		l.expanding = l.expanding[:len(l.expanding)-1]
+	}()
+
+	// variables and templates are resolved where the template is defined.
+	c := t.sc.child("")
+	c.prefix = joinPath(sc.prefix, s.Path)
+	rules, err := l.evalStmts(t.file, t.block.Stmts, c)
+	if err != nil && t.file != name {
+		// the error is in the file where the template is defined.
+		var e *ParseError
+		if !errors.As(err, &e) {
+			return nil, &ParseError{File: t.file, Line: errorLine(err), Err: err}
+		}
+	}
+	return rules, err
+}
+
+func joinPath(prefix, path string) string {
+	if prefix == "" {
+		return path
+	}
+	return prefix + "." + path
+}
+
+func evalRule(s *RuleStmt, sc *scope) (*Rule, error) {
+	r := &Rule{
+		Required: !s.Optional,
+		Severity: severityOf(s.Severity),
+		Labels:   s.Labels,
+		Removed:  s.Removed,
+	}
+	r.Path = joinPath(sc.prefix, s.Path)
+	exprs, err := evalExprs(s.Exprs, sc)
+	if err != nil {
+		return nil, err
+	}
+	r.Exprs = exprs
+	return r, nil
+}
+
+// evalGroup evaluates rules in the group; the rules are not required individually.
+func evalGroup(s *GroupBlock, sc *scope) ([]*Rule, error) {
+	var (
+		rules []*Rule
+		ann   annotation
+	)
+	for _, stmt := range s.Stmts {
+		switch stmt := stmt.(type) {
+		case *Comment:
+			ann.add(stmt)
+			continue
+		case *BlankLine:
+		case *RuleStmt:
+			if stmt.Removed {
+				return nil, &posError{stmt.Start, errors.New("the removal is not allowed in the group")}
+			}
+			r, err := evalRule(stmt, sc)
+			if err != nil {
+				return nil, err
+			}
+			ann.apply(r)
+			r.Required = false
+			if stmt.Severity == "" {
+				r.Severity = severityOf(s.Severity)
+			}
+			rules = append(rules, r)
+		default:
+			return nil, &posError{stmt.Pos(), errors.New("only rules are allowed in the group")}
+		}
+		ann = annotation{}
+	}
+	if len(rules) < s.Min {
+		return nil, &posError{s.Start, fmt.Errorf("the group has %d rules, but at least %d rules must be matched", len(rules), s.Min)}
+	}
+	g := newGroup(s.Min, s.Max, rules)
+	g.Severity = severityOf(s.Severity)
+	return rules, nil
+}
+
+// severityOf returns the severity named s that is validated by the parser.
+// It returns SeverityError if s is empty.
+func severityOf(s string) Severity {
+	if s == "" {
+		return SeverityError
+	}
+	return severityNames[s]
+}
+
+func evalWhen(s *WhenStmt, sc *scope) (*Rule, error) {
+	r, err := evalRule(s.Rule, sc)
+	if err != nil {
+		return nil, err
+	}
+	exprs, err := evalExprs(s.Exprs, sc)
+	if err != nil {
+		return nil, err
+	}
+	if s.Severity != "" {
+		r.Severity = severityOf(s.Severity)
+	}
+	r.When = &Condition{
+		Path:  joinPath(sc.prefix, s.Path),
+		Exprs: exprs,
+	}
+	return r, nil
+}
+
+// evalExprs evaluates expressions that all must be satisfied.
+// Expressions in parentheses are flattened into the result.
+func evalExprs(a []ExprNode, sc *scope) ([]*Expr, error) {
+	var exprs []*Expr
+	for _, e := range a {
+		switch e := e.(type) {
+		case *CmpExpr:
+			v, err := evalNumber(e.X, sc)
+			if err != nil {
+				return nil, err
+			}
+			x := &Expr{Op: e.Op, Value: v}
+			if e.Left != nil {
+				if x.X, err = evalValue(e.Left, sc); err != nil {
+					return nil, err
+				}
+			}
+			exprs = append(exprs, x)
+		case *AssertExpr:
+			exprs = append(exprs, &Expr{Op: e.Op})
+		case *OrExpr:
+			x := &Expr{Op: Or}
+			for _, alt := range e.Alts {
+				v, err := evalExprs(alt, sc)
+				if err != nil {
+					return nil, err
+				}
+				x.Alts = append(x.Alts, v)
+			}
+			exprs = append(exprs, x)
+		case *RangeExpr:
+			low, err := evalNumber(e.Low, sc)
+			if err != nil {
+				return nil, err
+			}
+			high, err := evalNumber(e.High, sc)
+			if err != nil {
+				return nil, err
+			}
+			lop, hop := GreaterEqual, LessEqual
+			if e.Open == "(" {
+				lop = GreaterThan
+			}
+			if e.Close == ")" {
+				hop = LessThan
+			}
+			exprs = append(exprs, &Expr{Op: lop, Value: low}, &Expr{Op: hop, Value: high})
+		case *ParenExpr:
+			v, err := evalExprs(e.Exprs, sc)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, v...)
+		default:
+			panic(fmt.Sprintf("unknown expression %T", e))
+		}
+	}
+	return exprs, nil
+}
+
+// evalValue evaluates an arithmetic operation.
+func evalValue(e ExprNode, sc *scope) (ValueExpr, error) {
+	switch e := e.(type) {
+	case *ValueRef:
+		return valueRef{}, nil
+	case *NumberLit:
+		v, err := evalNumber(e, sc)
+		if err != nil {
+			return nil, err
+		}
+		return constValue(v), nil
+	case *ParenExpr:
+		return evalValue(e.Exprs[0], sc)
+	case *UnaryExpr:
+		x, err := evalValue(e.X, sc)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == "-" {
+			return &negValue{x: x}, nil
+		}
+		return x, nil
+	case *CallExpr:
+		x, err := evalValue(e.X, sc)
+		if err != nil {
+			return nil, err
+		}
+		return &callValue{name: e.Func, x: x}, nil
+	case *BinaryExpr:
+		x, err := evalValue(e.X, sc)
+		if err != nil {
+			return nil, err
+		}
+		y, err := evalValue(e.Y, sc)
+		if err != nil {
+			return nil, err
+		}
+		return &binaryValue{op: e.Op, x: x, y: y}, nil
+	default:
+		panic(fmt.Sprintf("unknown expression %T", e))
+	}
+}
+
+func evalNumber(n *NumberLit, sc *scope) (float64, error) {
+	if n.Var == "" {
+		return n.Value, nil
+	}
+	v, err := sc.lookup(n.Var)
+	if err != nil {
+		return 0, &posError{n.Start, err}
+	}
+	return v, nil
+}
+
+// include loads files matched to the pattern of s, and adds definitions in the files to sc.
+func (l *loader) include(name string, s *IncludeStmt, sc *scope) ([]*Rule, error) {
+	if l.fsys == nil {
+		return nil, errNoFS
+	}
+	pattern := path.Join(path.Dir(name), s.Pattern)
+	if path.IsAbs(s.Pattern) {
+		pattern = strings.TrimPrefix(path.Clean(s.Pattern), "/")
+		if pattern == "" {
+			pattern = "."
+		}
+	}
+	names, err := fs.Glob(l.fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 && !hasMeta(s.Pattern) {
+		return nil, fmt.Errorf("%s: %w", pattern, fs.ErrNotExist)
+	}
+	var rules []*Rule
+	for _, file := range names {
+		for i, v := range l.loading {
+			if v == file {
+				a := append(l.loading[i:len(l.loading):len(l.loading)], file)
+				return nil, fmt.Errorf("include cycle: %s", strings.Join(a, " -> "))
+			}
+		}
+		a, defs, err := l.load(file, sc.prefix)
+		if err != nil {
+			return nil, err
+		}
+		if err := sc.merge(defs); err != nil {
+			return nil, err
+		}
+		rules = append(rules, a...)
+	}
+	return rules, nil
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
@@ -0,0 +1,195 @@
+package graphitemetrictest
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadRulesFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.rules":            {Data: []byte("include \"plugins/*.rules\" // all plugins\na.b.c >0\n")},
+		"common.rules":          {Data: []byte("~x.y\n")},
+		"plugins/disk.rules":    {Data: []byte("include \"../common.rules\"\ndisk.reads\n")},
+		"plugins/network.rules": {Data: []byte("include \"../common.rules\"\nnetwork.rx\n")},
+	}
+	want := []*Rule{
+		{Required: false, Path: "x.y"},
+		{Required: true, Path: "disk.reads"},
+		{Required: true, Path: "network.rx"},
+		{Required: true, Path: "a.b.c", Exprs: []*Expr{{Op: GreaterThan, Value: 0}}},
+	}
+	a, err := ReadRulesFS(fsys, "main.rules")
+	if err != nil {
+		t.Fatalf("ReadRulesFS: %v", err)
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ReadRulesFS = %v; want %v", a, want)
+	}
+}
+
+func TestReadRulesFS_prefix(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.rules": {Data: []byte("prefix a {\n\tinclude \"nic.rules\"\n}\nprefix b {\n\tinclude \"nic.rules\"\n}\n")},
+		"nic.rules":  {Data: []byte("rx >=0\n")},
+	}
+	want := []*Rule{
+		{Required: true, Path: "a.rx", Exprs: []*Expr{{Op: GreaterEqual, Value: 0}}},
+		{Required: true, Path: "b.rx", Exprs: []*Expr{{Op: GreaterEqual, Value: 0}}},
+	}
+	a, err := ReadRulesFS(fsys, "main.rules")
+	if err != nil {
+		t.Fatalf("ReadRulesFS: %v", err)
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ReadRulesFS = %v; want %v", a, want)
+	}
+}
+
+func TestReadRulesFS_definitions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.rules":     {Data: []byte("include \"a.rules\"\ninclude \"b.rules\"\nuse nic at x\ny <$LIMIT\n")},
+		"a.rules":        {Data: []byte("include \"common.rules\"\n")},
+		"b.rules":        {Data: []byte("include \"common.rules\"\n")},
+		"common.rules":   {Data: []byte("let LIMIT = 10\ntemplate nic {\n\trx <$LIMIT\n}\n")},
+		"etc/root.rules": {Data: []byte("include \"/common.rules\"\nuse nic at z\n")},
+	}
+	tests := []struct {
+		pattern string
+		want    []*Rule
+	}{
+		{
+			pattern: "main.rules",
+			want: []*Rule{
+				{Required: true, Path: "x.rx", Exprs: []*Expr{{Op: LessThan, Value: 10}}},
+				{Required: true, Path: "y", Exprs: []*Expr{{Op: LessThan, Value: 10}}},
+			},
+		},
+		{
+			pattern: "etc/root.rules",
+			want: []*Rule{
+				{Required: true, Path: "z.rx", Exprs: []*Expr{{Op: LessThan, Value: 10}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		a, err := ReadRulesFS(fsys, tt.pattern)
+		if err != nil {
+			t.Fatalf("ReadRulesFS(%q): %v", tt.pattern, err)
+		}
+		if !reflect.DeepEqual(a, tt.want) {
+			t.Errorf("ReadRulesFS(%q) = %v; want %v", tt.pattern, a, tt.want)
+		}
+	}
+}
+
+func TestReadRulesFS_error(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		pattern string
+		file    string
+		line    int
+		err     error
+	}{
+		{
+			name:    "no matching files",
+			fsys:    fstest.MapFS{},
+			pattern: "*.rules",
+		},
+		{
+			name: "not exist",
+			fsys: fstest.MapFS{
+				"main.rules": {Data: []byte("a.b\ninclude \"x.rules\"\n")},
+			},
+			pattern: "main.rules",
+			file:    "main.rules",
+			line:    2,
+			err:     fs.ErrNotExist,
+		},
+		{
+			name: "cycle",
+			fsys: fstest.MapFS{
+				"main.rules":  {Data: []byte("include \"a/x.rules\"\n")},
+				"a/x.rules":   {Data: []byte("include \"../b/y.rules\"\n")},
+				"b/y.rules":   {Data: []byte("\n\ninclude \"../main.rules\"\n")},
+				"unused.rule": {Data: []byte("")},
+			},
+			pattern: "main.rules",
+			file:    "b/y.rules",
+			line:    3,
+		},
+		{
+			name: "error in included template",
+			fsys: fstest.MapFS{
+				"main.rules": {Data: []byte("a\ninclude \"t.rules\"\n\nuse t at x\n")},
+				"t.rules":    {Data: []byte("template t {\n\tb <$GRAPHITEMETRICTEST_UNDEFINED\n}\n")},
+			},
+			pattern: "main.rules",
+			file:    "t.rules",
+			line:    2,
+		},
+		{
+			name: "duplicate variable",
+			fsys: fstest.MapFS{
+				"main.rules": {Data: []byte("let X = 1\ninclude \"x.rules\"\n")},
+				"x.rules":    {Data: []byte("let X = 1\n")},
+			},
+			pattern: "main.rules",
+			file:    "main.rules",
+			line:    2,
+		},
+		{
+			name: "duplicate template",
+			fsys: fstest.MapFS{
+				"main.rules": {Data: []byte("include \"x.rules\"\ninclude \"y.rules\"\n")},
+				"x.rules":    {Data: []byte("template t {\n\ta\n}\n")},
+				"y.rules":    {Data: []byte("template t {\n\tb\n}\n")},
+			},
+			pattern: "main.rules",
+			file:    "main.rules",
+			line:    2,
+		},
+		{
+			name: "syntax error",
+			fsys: fstest.MapFS{
+				"main.rules": {Data: []byte("include \"x.rules\"\n")},
+				"x.rules":    {Data: []byte("a.b\n\na.b >\n")},
+			},
+			pattern: "main.rules",
+			file:    "x.rules",
+			line:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadRulesFS(tt.fsys, tt.pattern)
+			if err == nil {
+				t.Fatalf("ReadRulesFS(%q) should return an error", tt.pattern)
+			}
+			if tt.file == "" {
+				return
+			}
+			var e *ParseError
+			if !errors.As(err, &e) {
+				t.Fatalf("ReadRulesFS(%q) = %v; want *ParseError", tt.pattern, err)
+			}
+			if e.File != tt.file || e.Line != tt.line {
+				t.Errorf("ReadRulesFS(%q) = %v; want %s:%d", tt.pattern, err, tt.file, tt.line)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("ReadRulesFS(%q) = %v; want %v", tt.pattern, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadRules_include(t *testing.T) {
+	_, err := ReadRules(strings.NewReader("include \"a.rules\"\n"))
+	if !errors.Is(err, errNoFS) {
+		t.Errorf("ReadRules = %v; want %v", err, errNoFS)
+	}
+}
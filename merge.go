@@ -0,0 +1,91 @@
+package graphitemetrictest
+
+import "strings"
+
+// Merge returns rules that overlays are applied to base in order.
+//
+// A rule in the overlay replaces all rules that have the same path in preceding rules,
+// so that the overlay can change expressions, make the rule optional, and so on.
+// Wildcards '*' and '#' in paths are not distinguished.
+// If the replaced rule is a member of a group, the rule in the overlay takes its place in the group.
+// If the rule is Removed, it only removes them.
+// Removed rules in base are dropped.
+// Rules and groups passed to Merge are not modified.
+func Merge(base []*Rule, overlays ...[]*Rule) []*Rule {
+	rules := dropRemoved(base)
+	for _, overlay := range overlays {
+		rules = mergeOverlay(rules, overlay)
+	}
+	return rules
+}
+
+func mergeOverlay(rules, overlay []*Rule) []*Rule {
+	paths := make(map[string][]*Rule)
+	for _, r := range overlay {
+		k := pathKey(r.Path)
+		paths[k] = append(paths[k], r)
+	}
+
+	// groups maps groups that have replaced members to their copies.
+	groups := make(map[*Group]*Group)
+	for _, r := range rules {
+		if r.Group != nil && paths[pathKey(r.Path)] != nil && groups[r.Group] == nil {
+			g := *r.Group
+			groups[r.Group] = &g
+		}
+	}
+	members := make(map[*Rule][]*Rule) // members of groups to their copies or replacements.
+	replaced := make(map[*Rule]*Rule)  // rules in the overlay to their copies in groups.
+	a := make([]*Rule, 0, len(rules)+len(overlay))
+	for _, r := range rules {
+		g := groups[r.Group]
+		overrides := paths[pathKey(r.Path)]
+		switch {
+		case overrides != nil && g != nil:
+			for _, o := range overrides {
+				if o.Removed || o.Group != nil || replaced[o] != nil {
+					continue
+				}
+				c := *o
+				c.Group = g
+				replaced[o] = &c
+				members[r] = append(members[r], &c)
+			}
+		case overrides != nil:
+		case g != nil:
+			c := *r
+			c.Group = g
+			members[r] = []*Rule{&c}
+			a = append(a, &c)
+		default:
+			a = append(a, r)
+		}
+	}
+	for _, r := range dropRemoved(overlay) {
+		if c := replaced[r]; c != nil {
+			r = c
+		}
+		a = append(a, r)
+	}
+	for orig, g := range groups {
+		g.Rules = nil
+		for _, r := range orig.Rules {
+			g.Rules = append(g.Rules, members[r]...)
+		}
+	}
+	return a
+}
+
+func dropRemoved(rules []*Rule) []*Rule {
+	a := make([]*Rule, 0, len(rules))
+	for _, r := range rules {
+		if !r.Removed {
+			a = append(a, r)
+		}
+	}
+	return a
+}
+
+func pathKey(s string) string {
+	return strings.Join(splitMetricName(s), ".")
+}
@@ -0,0 +1,90 @@
+package graphitemetrictest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := `a.b >0
+a.# >=0
+c
+d
+-e
+`
+	overlays := []string{
+		"a.b >5\n~c\n-d\n",
+		"a.* <10\nf\n",
+	}
+	rules, err := ReadRules(strings.NewReader(base))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", base, err)
+	}
+	var layers [][]*Rule
+	for _, s := range overlays {
+		a, err := ReadRules(strings.NewReader(s))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", s, err)
+		}
+		layers = append(layers, a)
+	}
+	a := Merge(rules, layers...)
+	want := []string{"a.b[>5]", "~c[]", "a.*[<10]", "f[]"}
+	if len(a) != len(want) {
+		t.Fatalf("Merge(...) = %v; want %v", a, want)
+	}
+	for i, r := range a {
+		if s := r.String(); s != want[i] {
+			t.Errorf("Merge(...)[%d] = %q; want %q", i, s, want[i])
+		}
+	}
+}
+
+func TestMerge_base(t *testing.T) {
+	rules := []*Rule{{Path: "a"}, {Path: "b", Removed: true}}
+	a := Merge(rules)
+	if len(a) != 1 || a[0] != rules[0] {
+		t.Errorf("Merge(%v) = %v; want [%v]", rules, a, rules[0])
+	}
+}
+
+func TestMerge_group(t *testing.T) {
+	base, err := ReadRules(strings.NewReader("oneof { a.x >0; a.y >0 }\nb\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := ReadRules(strings.NewReader("~a.x >5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Merge(base, overlay)
+	want := []string{"~a.y[>0]", "b[]", "~a.x[>5]"}
+	if len(a) != len(want) {
+		t.Fatalf("Merge(...) = %v; want %v", a, want)
+	}
+	for i, r := range a {
+		if s := r.String(); s != want[i] {
+			t.Errorf("Merge(...)[%d] = %q; want %q", i, s, want[i])
+		}
+	}
+	g := a[2].Group
+	if g == nil || g != a[0].Group {
+		t.Fatalf("Merge(...)[2].Group = %v; want the group of %v", g, a[0])
+	}
+	if len(g.Rules) != 2 || g.Rules[0] != a[2] || g.Rules[1] != a[0] {
+		t.Errorf("Group.Rules = %v; want [%v %v]", g.Rules, a[2], a[0])
+	}
+	if r := base[0].Group.Rules[0]; r != base[0] {
+		t.Errorf("base group is modified: %v", base[0].Group)
+	}
+
+	metrics := []*Metric{{Path: "a.x", Value: 10}, {Path: "b", Value: 1}}
+	if d := Diff(a, metrics); len(d) != 0 {
+		t.Errorf("Diff(%v, %v) = %v; want none", a, metrics, d)
+	}
+	metrics[0].Value = 3
+	d := Diff(a, metrics)
+	if len(d) != 2 || d[1].Group != g || d[1].Count != 0 {
+		t.Errorf("Diff(%v, %v) = %v; want the violation of a.x and the group", a, metrics, d)
+	}
+}
@@ -0,0 +1,165 @@
+package graphitemetrictest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// NamingStyle represents a letter case convention for path segments.
+type NamingStyle uint8
+
+// Naming styles.
+const (
+	StyleAny       NamingStyle = iota // any letter case
+	StyleLowercase                    // no upper case letters
+	StyleSnakeCase                    // lower case words separated by '_'
+)
+
+// String returns the name of the style.
+func (s NamingStyle) String() string {
+	switch s {
+	case StyleAny:
+		return "any"
+	case StyleLowercase:
+		return "lowercase"
+	case StyleSnakeCase:
+		return "snake_case"
+	default:
+		panic("unknown naming style")
+	}
+}
+
+// NamingPolicy represents naming conventions for metric paths.
+//
+// Each limit is disabled when it is zero.
+type NamingPolicy struct {
+	IsValidChar      func(c rune) bool // reports whether c is allowed in segments; nil allows [A-Za-z0-9_-].
+	MaxDepth         int               // maximum number of segments.
+	MaxLength        int               // maximum length of the path in bytes.
+	MaxSegmentLength int               // maximum length of each segments in bytes.
+	Style            NamingStyle
+	NoNumeric        bool // disallows segments consisted of only digits.
+	NoUUID           bool // disallows segments looks like UUID.
+}
+
+// DefaultNamingPolicy is a policy that is suitable for most of whisper backends.
+//
+// Whisper stores a metric to the file named the last segment plus ".wsp",
+// so each segments must be shorter than 255 bytes of the file name limit.
+var DefaultNamingPolicy = &NamingPolicy{
+	MaxSegmentLength: 255 - len(".wsp"),
+	Style:            StyleLowercase,
+	NoNumeric:        true,
+	NoUUID:           true,
+}
+
+var (
+	errEmptySegment = errors.New("empty segment")
+	errNumeric      = errors.New("segment consisted of only digits")
+	errUUID         = errors.New("segment looks like UUID")
+
+	snakeCaseRegexp = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)*$`)
+	uuidRegexp      = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+)
+
+// NamingError represents a violation of the naming policy.
+type NamingError struct {
+	Metric  *Metric
+	Segment int // index of the segment; -1 if the violation is for whole path.
+	Err     error
+}
+
+// Error returns a string representation of an error.
+func (e *NamingError) Error() string {
+	if e.Segment < 0 {
+		return fmt.Sprintf("metric %s: %v", e.Metric.Path, e.Err)
+	}
+	return fmt.Sprintf("metric %s: segment %d: %v", e.Metric.Path, e.Segment+1, e.Err)
+}
+
+// Unwrap returns an error.
+func (e *NamingError) Unwrap() error { return e.Err }
+
+// Check checks paths of metrics and returns violations of the policy.
+//
+// Tags of the metric, after ';' in the path, are not checked.
+func (p *NamingPolicy) Check(metrics []*Metric) []*NamingError {
+	var results []*NamingError
+	for _, m := range metrics {
+		results = append(results, p.checkPath(m)...)
+	}
+	return results
+}
+
+func (p *NamingPolicy) checkPath(m *Metric) []*NamingError {
+	var results []*NamingError
+	report := func(i int, err error) {
+		results = append(results, &NamingError{Metric: m, Segment: i, Err: err})
+	}
+
+	path, _, _ := strings.Cut(m.Path, ";")
+	if p.MaxLength > 0 && len(path) > p.MaxLength {
+		report(-1, fmt.Errorf("path is longer than %d bytes", p.MaxLength))
+	}
+	a := strings.Split(path, ".")
+	if p.MaxDepth > 0 && len(a) > p.MaxDepth {
+		report(-1, fmt.Errorf("path is deeper than %d segments", p.MaxDepth))
+	}
+	for i, s := range a {
+		if err := p.checkSegment(s); err != nil {
+			report(i, err)
+		}
+	}
+	return results
+}
+
+func (p *NamingPolicy) checkSegment(s string) error {
+	if s == "" {
+		return errEmptySegment
+	}
+	if p.MaxSegmentLength > 0 && len(s) > p.MaxSegmentLength {
+		return fmt.Errorf("segment is longer than %d bytes", p.MaxSegmentLength)
+	}
+	isValidChar := p.IsValidChar
+	if isValidChar == nil {
+		isValidChar = isNameChar
+	}
+	for _, c := range s {
+		if !isValidChar(c) {
+			return fmt.Errorf("invalid character %q", c)
+		}
+	}
+	switch p.Style {
+	case StyleLowercase:
+		if strings.IndexFunc(s, unicode.IsUpper) >= 0 {
+			return fmt.Errorf("segment is not %v", p.Style)
+		}
+	case StyleSnakeCase:
+		if !snakeCaseRegexp.MatchString(s) {
+			return fmt.Errorf("segment is not %v", p.Style)
+		}
+	}
+	if p.NoNumeric && strings.IndexFunc(s, isNotDigit) < 0 {
+		return errNumeric
+	}
+	if p.NoUUID && uuidRegexp.MatchString(s) {
+		return errUUID
+	}
+	return nil
+}
+
+func isNameChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	default:
+		return c == '_' || c == '-'
+	}
+}
+
+func isNotDigit(c rune) bool {
+	return c < '0' || c > '9'
+}
@@ -0,0 +1,121 @@
+package graphitemetrictest
+
+import (
+	"errors"
+	"testing"
+	"unicode"
+)
+
+func TestNamingPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *NamingPolicy
+		path     string
+		segments []int
+	}{
+		{
+			name:     "default/ok",
+			policy:   DefaultNamingPolicy,
+			path:     "custom.disks.sda0.reads.bytes",
+			segments: nil,
+		},
+		{
+			name:     "default/tags",
+			policy:   DefaultNamingPolicy,
+			path:     "custom.disks.reads;device=sda0",
+			segments: nil,
+		},
+		{
+			name:     "default/empty segment",
+			policy:   DefaultNamingPolicy,
+			path:     "custom..reads",
+			segments: []int{1},
+		},
+		{
+			name:     "default/upper case",
+			policy:   DefaultNamingPolicy,
+			path:     "custom.Disks.reads",
+			segments: []int{1},
+		},
+		{
+			name:     "default/invalid character",
+			policy:   DefaultNamingPolicy,
+			path:     "custom.disks/sda.reads",
+			segments: []int{1},
+		},
+		{
+			name:     "default/numeric",
+			policy:   DefaultNamingPolicy,
+			path:     "custom.cpu.0.user",
+			segments: []int{2},
+		},
+		{
+			name:     "default/uuid",
+			policy:   DefaultNamingPolicy,
+			path:     "custom.vm.3f2504e0-4f89-11d3-9a0c-0305e82c3301.cpu",
+			segments: []int{2},
+		},
+		{
+			name:     "snake_case",
+			policy:   &NamingPolicy{Style: StyleSnakeCase},
+			path:     "custom.read_bytes.write-bytes._x",
+			segments: []int{2, 3},
+		},
+		{
+			name:     "depth and length",
+			policy:   &NamingPolicy{MaxDepth: 2, MaxLength: 5, MaxSegmentLength: 2},
+			path:     "a.bb.ccc",
+			segments: []int{-1, -1, 2},
+		},
+		{
+			name: "custom characters",
+			policy: &NamingPolicy{
+				IsValidChar: func(c rune) bool { return unicode.IsLetter(c) },
+			},
+			path:     "custom.ディスク.sda0",
+			segments: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Metric{Path: tt.path}
+			a := tt.policy.Check([]*Metric{m})
+			if len(a) != len(tt.segments) {
+				t.Fatalf("Check(%q) = %v; want %d errors", tt.path, a, len(tt.segments))
+			}
+			for i, e := range a {
+				if e.Metric != m {
+					t.Errorf("Metric = %v; want %v", e.Metric, m)
+				}
+				if e.Segment != tt.segments[i] {
+					t.Errorf("%v: Segment = %d; want %d", e, e.Segment, tt.segments[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNamingError(t *testing.T) {
+	err := errors.New("err")
+	tests := []struct {
+		e    *NamingError
+		want string
+	}{
+		{
+			e:    &NamingError{Metric: &Metric{Path: "a.b"}, Segment: -1, Err: err},
+			want: "metric a.b: err",
+		},
+		{
+			e:    &NamingError{Metric: &Metric{Path: "a.b"}, Segment: 0, Err: err},
+			want: "metric a.b: segment 1: err",
+		},
+	}
+	for _, tt := range tests {
+		if s := tt.e.Error(); s != tt.want {
+			t.Errorf("Error() = %q; want %q", s, tt.want)
+		}
+		if e := tt.e.Unwrap(); e != err {
+			t.Errorf("Unwrap() = %v; want %v", e, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 	"unicode"
@@ -12,12 +13,16 @@ import (
 
 // ParseError is returned for parsing errors.
 type ParseError struct {
+	File string // name of the rule file; it can be empty.
 	Line int
 	Err  error
 }
 
 // Error returns a string representation of an error.
 func (e *ParseError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("parse error on %s:%d: %v", e.File, e.Line, e.Err)
+	}
 	return fmt.Sprintf("parse error on line %d: %v", e.Line, e.Err)
 }
 
@@ -27,6 +32,8 @@ func (e *ParseError) Unwrap() error { return e.Err }
 var errFields = errors.New("a metric must be consisted of three fields")
 
 // ReadMetrics reads r and returns metrics.
+// Values such as NaN and Inf are read as they are; use NonFinite to find them,
+// or the finite assertion in rules to reject them.
 func ReadMetrics(r io.Reader) ([]*Metric, error) {
 	var metrics []*Metric
 
@@ -38,229 +45,1326 @@ func ReadMetrics(r io.Reader) ([]*Metric, error) {
 		if s == "" {
 			continue
 		}
-		a := strings.Fields(s)
-		if len(a) != 3 {
-			return nil, &ParseError{Line: lineno, Err: errFields}
-		}
-		value, err := strconv.ParseFloat(a[1], 64)
+		m, err := parseMetric(s)
 		if err != nil {
 			return nil, &ParseError{Line: lineno, Err: err}
 		}
-		tick, err := strconv.ParseInt(a[2], 10, 64)
+		metrics = append(metrics, m)
+	}
+	if err := f.Err(); err != nil {
+		return nil, &ParseError{Line: lineno, Err: err}
+	}
+	return metrics, nil
+}
+
+// NonFinite returns metrics whose values are NaN or infinity.
+// They are valid in the protocol, but they are often caused by division by zero or overflow.
+func NonFinite(metrics []*Metric) []*Metric {
+	var a []*Metric
+	for _, m := range metrics {
+		if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			a = append(a, m)
+		}
+	}
+	return a
+}
+
+func parseMetric(s string) (*Metric, error) {
+	a := strings.Fields(s)
+	if len(a) != 3 {
+		return nil, errFields
+	}
+	value, err := strconv.ParseFloat(a[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	tick, err := strconv.ParseInt(a[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Metric{
+		Path:      a[0],
+		Value:     value,
+		Timestamp: tick,
+	}, nil
+}
+
+// ReadRules reads r and returns rules.
+func ReadRules(r io.Reader) ([]*Rule, error) {
+	f, err := ParseFile("", r)
+	if err != nil {
+		return nil, err
+	}
+	return f.Rules()
+}
+
+// ParseFile parses r as a rule file and returns its syntax tree.
+// The name is used to identify the file.
+func ParseFile(name string, r io.Reader) (*File, error) {
+	p := newParser(r)
+	stmts, _, err := p.parseStmts(false)
+	if err != nil {
+		return nil, &ParseError{File: name, Line: p.line, Err: err}
+	}
+	return &File{Name: name, Stmts: stmts}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenTilde tokenKind = iota
+	tokenLessThan
+	tokenLessEqual
+	tokenGreaterThan
+	tokenGreaterEqual
+	tokenText
+	tokenNumber
+	tokenComma
+	tokenNewline
+	tokenComment
+	tokenString
+	tokenLBrace
+	tokenRBrace
+	tokenAssign
+	tokenVar
+	tokenSemicolon
+	tokenBar
+	tokenLParen
+	tokenRParen
+	tokenLBrack
+	tokenRBrack
+	tokenDotDot
+	tokenIdent
+	tokenPlus
+	tokenMinus
+	tokenStar
+	tokenSlash
+	tokenPercent
+	tokenEqual
+	tokenNotEqual
+	tokenLabel
+	tokenEOF
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+}
+
+type parser struct {
+	r      *bufio.Reader
+	pos    Pos      // position of the next rune
+	last   Pos      // position of the last rune read
+	line   int      // line of the last token read
+	peek   []*token // tokens pushed back by unreadToken
+	expr   bool     // identifiers and arithmetic operators are tokens in expressions
+	labels bool     // words starting with a letter are labels
+}
+
+func newParser(r io.Reader) *parser {
+	return &parser{
+		r:   bufio.NewReader(r),
+		pos: Pos{Line: 1, Col: 1},
+	}
+}
+
+// parseStmts reads statements until the end of the file, or '}' if inBlock is true.
+// It returns the '}' token that closes the block.
+func (p *parser) parseStmts(inBlock bool) ([]Stmt, *token, error) {
+	var stmts []Stmt
+	for {
+		t, err := p.readToken()
 		if err != nil {
-			return nil, &ParseError{Line: lineno, Err: err}
+			return nil, nil, err
+		}
+		switch {
+		case t.kind == tokenEOF && inBlock:
+			return nil, nil, errors.New("expected '}', but got EOF")
+		case t.kind == tokenEOF:
+			return stmts, nil, nil
+		case t.kind == tokenRBrace && inBlock:
+			return stmts, t, nil
+		case t.kind == tokenSemicolon:
+			continue
+		}
+		stmt, err := p.parseStmt(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+}
+
+func (p *parser) parseStmt(t *token) (Stmt, error) {
+	switch t.kind {
+	case tokenNewline:
+		return &BlankLine{Start: t.pos}, nil
+	case tokenComment:
+		c := &Comment{Start: t.pos, Text: t.text}
+		if err := p.parseEOL(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case tokenText:
+		if isSeverity(t) {
+			return p.parseSeverity(t)
+		}
+		if !isKeyword(t.text) {
+			return p.parseRule(t)
+		}
+		// keywords are followed by an argument; otherwise it is a path.
+		t1, err := p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t.text == "include" && t1.kind == tokenString:
+			return p.parseInclude(t, t1)
+		case t.text == "prefix" && t1.kind == tokenText:
+			return p.parsePrefix(t, t1)
+		case t.text == "let" && t1.kind == tokenText:
+			return p.parseLet(t, t1)
+		case t.text == "template" && t1.kind == tokenText:
+			return p.parseTemplate(t, t1)
+		case t.text == "use" && t1.kind == tokenText:
+			return p.parseUse(t, t1)
+		case t.text == "when" && t1.kind == tokenText:
+			return p.parseWhen(t, t1)
+		case t.text == "oneof" && t1.kind == tokenLBrace:
+			p.unreadToken(t1)
+			return p.parseGroup(t, 1, 1)
+		case t.text == "atleast" && t1.kind == tokenNumber:
+			n, err := strconv.Atoi(t1.text)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("expected a positive integer, but got %s", t1.text)
+			}
+			return p.parseGroup(t, n, 0)
+		}
+		p.unreadToken(t1)
+		return p.parseRule(t)
+	default:
+		return p.parseRule(t)
+	}
+}
+
+// parseWhen reads a conditional rule such as "when a.role ==1 require a.lag >=0".
+func (p *parser) parseWhen(t, t1 *token) (*WhenStmt, error) {
+	stmt := WhenStmt{Start: t.pos, Path: t1.text}
+	var err error
+	stmt.Exprs, err = p.parseExprsUntil(func(t *token) bool {
+		return isEndOfStmt(t) || isRequire(t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	t2, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if !isRequire(t2) {
+		return nil, fmt.Errorf("expected 'require', but got %s", t2.text)
+	}
+	t3, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Rule, err = p.parseRule(t3)
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Rule.Removed {
+		return nil, fmt.Errorf("the removal of %s cannot be required", stmt.Rule.Path)
+	}
+	return &stmt, nil
+}
+
+func isRequire(t *token) bool {
+	return t.kind == tokenIdent && t.text == "require"
+}
+
+// parseSeverity reads a statement annotated by the severity such as "@warn a.b >0".
+func (p *parser) parseSeverity(t *token) (Stmt, error) {
+	t1, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if isSeverity(t1) {
+		return nil, fmt.Errorf("%s is specified twice with %s", t1.text, t.text)
+	}
+	stmt, err := p.parseStmt(t1)
+	if err != nil {
+		return nil, err
+	}
+	severity := t.text[1:]
+	switch s := stmt.(type) {
+	case *RuleStmt:
+		if s.Removed {
+			return nil, fmt.Errorf("%s is not allowed before the removal", t.text)
+		}
+		s.Start = t.pos
+		s.Severity = severity
+	case *WhenStmt:
+		s.Start = t.pos
+		s.Severity = severity
+	case *GroupBlock:
+		s.Start = t.pos
+		s.Severity = severity
+	default:
+		return nil, fmt.Errorf("%s is not allowed before the statement", t.text)
+	}
+	return stmt, nil
+}
+
+func isSeverity(t *token) bool {
+	if t.kind != tokenText || !strings.HasPrefix(t.text, "@") {
+		return false
+	}
+	_, ok := severityNames[t.text[1:]]
+	return ok
+}
+
+func isKeyword(s string) bool {
+	switch s {
+	case "include", "prefix", "let", "template", "use", "when", "oneof", "atleast":
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *parser) parseInclude(t, t1 *token) (*IncludeStmt, error) {
+	stmt := IncludeStmt{Start: t.pos, Pattern: t1.text}
+	c, err := p.parseComment()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Comment = c
+	return &stmt, nil
+}
+
+func (p *parser) parsePrefix(t, t1 *token) (*PrefixBlock, error) {
+	block := PrefixBlock{Start: t.pos, Prefix: t1.text}
+	var err error
+	block.Comment, block.Stmts, block.End, block.EndComment, err = p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (p *parser) parseTemplate(t, t1 *token) (*TemplateBlock, error) {
+	block := TemplateBlock{Start: t.pos, Name: t1.text}
+	var err error
+	block.Comment, block.Stmts, block.End, block.EndComment, err = p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// parseBlock reads "{ stmts }" and trailing comments after both braces.
+func (p *parser) parseBlock() (c *Comment, stmts []Stmt, end Pos, endComment *Comment, err error) {
+	t, err := p.readToken()
+	if err != nil {
+		return
+	}
+	if t.kind != tokenLBrace {
+		err = fmt.Errorf("expected '{', but got %s", t.text)
+		return
+	}
+
+	// statements can follow '{' in the same line.
+	t, err = p.readToken()
+	if err != nil {
+		return
+	}
+	switch t.kind {
+	case tokenComment:
+		c = &Comment{Start: t.pos, Text: t.text}
+		if err = p.parseEOL(); err != nil {
+			return
+		}
+	case tokenNewline:
+	default:
+		p.unreadToken(t)
+	}
+
+	stmts, t, err = p.parseStmts(true)
+	if err != nil {
+		return
+	}
+	end = t.pos
+	endComment, err = p.parseComment()
+	return
+}
+
+func (p *parser) parseGroup(t *token, min, max int) (*GroupBlock, error) {
+	block := GroupBlock{Start: t.pos, Min: min, Max: max}
+	var err error
+	block.Comment, block.Stmts, block.End, block.EndComment, err = p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+func (p *parser) parseUse(t, t1 *token) (*UseStmt, error) {
+	stmt := UseStmt{Start: t.pos, Name: t1.text}
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenText || t.text != "at" {
+		return nil, fmt.Errorf("expected 'at', but got %s", t.text)
+	}
+	t, err = p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenText {
+		return nil, fmt.Errorf("expected a path, but got %s", t.text)
+	}
+	stmt.Path = t.text
+	stmt.Comment, err = p.parseComment()
+	if err != nil {
+		return nil, err
+	}
+	return &stmt, nil
+}
+
+func (p *parser) parseLet(t, t1 *token) (*LetStmt, error) {
+	stmt := LetStmt{Start: t.pos, Name: t1.text}
+	if !isVarName(stmt.Name) {
+		return nil, fmt.Errorf("invalid variable name %s", stmt.Name)
+	}
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenAssign {
+		return nil, fmt.Errorf("expected '=', but got %s", t.text)
+	}
+	stmt.Value, err = p.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Comment, err = p.parseComment()
+	if err != nil {
+		return nil, err
+	}
+	return &stmt, nil
+}
+
+// parseComment reads an optional trailing comment and the end of the statement.
+// The statement ends with '\n', ';' or '}' closing the block.
+func (p *parser) parseComment() (*Comment, error) {
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case tokenNewline, tokenEOF:
+		return nil, nil
+	case tokenRBrace:
+		p.unreadToken(t)
+		return nil, nil
+	case tokenSemicolon:
+		// '\n' just after ';' is not a blank line.
+		t, err := p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokenNewline {
+			p.unreadToken(t)
+		}
+		return nil, nil
+	case tokenComment:
+		if err := p.parseEOL(); err != nil {
+			return nil, err
+		}
+		return &Comment{Start: t.pos, Text: t.text}, nil
+	default:
+		return nil, fmt.Errorf("expected '\\n', but got %s", t.text)
+	}
+}
+
+// parseEOL reads the end of the line.
+func (p *parser) parseEOL() error {
+	t, err := p.readToken()
+	if err != nil {
+		return err
+	}
+	if t.kind != tokenNewline && t.kind != tokenEOF {
+		return fmt.Errorf("expected '\\n', but got %s", t.text)
+	}
+	return nil
+}
+
+func (p *parser) parseRule(t *token) (*RuleStmt, error) {
+	stmt := RuleStmt{Start: t.pos}
+
+	/*
+	 * metric path
+	 */
+	if t.kind == tokenTilde {
+		stmt.Optional = true
+		var err error
+		t, err = p.readToken()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read a path: %w", err)
+		}
+	}
+	if t.kind != tokenText {
+		return nil, fmt.Errorf("expected a path, but got %s", t.text)
+	}
+	stmt.Path = t.text
+	if !stmt.Optional && strings.HasPrefix(t.text, "-") {
+		// "-path" removes the rule in overlays.
+		stmt.Removed = true
+		stmt.Path = t.text[1:]
+		if stmt.Path == "" {
+			return nil, errors.New("expected a path after '-'")
+		}
+	}
+
+	/*
+	 * labels
+	 */
+	var err error
+	stmt.Labels, err = p.parseLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	/*
+	 * expressions
+	 */
+	stmt.Exprs, err = p.parseExprs()
+	if err != nil {
+		return nil, err
+	}
+	if stmt.Removed && (len(stmt.Labels) > 0 || len(stmt.Exprs) > 0) {
+		return nil, fmt.Errorf("the removal of %s cannot have labels or expressions", stmt.Path)
+	}
+	stmt.Comment, err = p.parseComment()
+	if err != nil {
+		return nil, err
+	}
+	return &stmt, nil
+}
+
+// parseLabels reads labels such as "[linux,mysql8]" after the path if any.
+// It distinguishes labels from the interval such as "[0, 1)" by the first token after '['.
+func (p *parser) parseLabels() ([]string, error) {
+	p.expr = true
+	defer func() {
+		p.expr = false
+		p.labels = false
+	}()
+
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenLBrack {
+		p.unreadToken(t)
+		return nil, nil
+	}
+	if len(p.peek) > 0 {
+		return nil, errors.New("cannot read labels after the lookahead")
+	}
+	p.labels = true
+	t1, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t1.kind != tokenLabel {
+		p.unreadToken(t1)
+		p.unreadToken(t)
+		return nil, nil
+	}
+	var labels []string
+	for {
+		if t1.kind != tokenLabel {
+			return nil, fmt.Errorf("expected a label, but got %s", t1.text)
+		}
+		labels = append(labels, t1.text)
+		t2, err := p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		switch t2.kind {
+		case tokenRBrack:
+			return labels, nil
+		case tokenComma:
+		default:
+			return nil, fmt.Errorf("expected ']' after labels, but got %s", t2.text)
+		}
+		if t1, err = p.readToken(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseExprs reads expressions until the end of the statement.
+//
+//	exprs    := and { '|' and }
+//	and      := primary { ',' primary }
+//	primary  := [ arith ] cmp | range | interval | assert | '(' exprs ')'
+//	assert   := 'int' | 'finite' | 'notnan' | 'nonneg' | 'safe'
+//	range    := number '..' number
+//	interval := ( '[' | '(' ) number ',' number ( ']' | ')' )
+//	arith    := term { ( '+' | '-' ) term }
+//	term     := factor { ( '*' | '/' | '%' ) factor }
+//	factor   := number | 'value' | func '(' arith ')' | '(' arith ')' | ( '-' | '+' ) factor
+//
+// It returns a list of expressions that all must be satisfied.
+func (p *parser) parseExprs() ([]ExprNode, error) {
+	return p.parseExprsUntil(isEndOfStmt)
+}
+
+// parseExprsUntil reads expressions until the token that end returns true.
+func (p *parser) parseExprsUntil(end func(t *token) bool) ([]ExprNode, error) {
+	p.expr = true
+	defer func() { p.expr = false }()
+
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	p.unreadToken(t)
+	if end(t) {
+		return nil, nil
+	}
+	exprs, err := p.parseOrExpr()
+	if err != nil {
+		return nil, err
+	}
+	t, err = p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if !end(t) {
+		return nil, fmt.Errorf("expected ',', but got %s", t.text)
+	}
+	p.unreadToken(t)
+	return exprs, nil
+}
+
+func (p *parser) parseOrExpr() ([]ExprNode, error) {
+	var alts [][]ExprNode
+	for {
+		exprs, err := p.parseAndExpr()
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, exprs)
+
+		t, err := p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokenBar {
+			p.unreadToken(t)
+			break
+		}
+	}
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+	return []ExprNode{&OrExpr{Start: alts[0][0].Pos(), Alts: alts}}, nil
+}
+
+func (p *parser) parseAndExpr() ([]ExprNode, error) {
+	var exprs []ExprNode
+	for {
+		e, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+
+		t, err := p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokenComma {
+			p.unreadToken(t)
+			return exprs, nil
+		}
+		// a trailing comma is allowed at the end of the statement.
+		t, err = p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		p.unreadToken(t)
+		if isEndOfStmt(t) {
+			return exprs, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (ExprNode, error) {
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := assertions[t.text]; t.kind == tokenIdent && ok {
+		return &AssertExpr{Start: t.pos, Op: op}, nil
+	}
+	switch t.kind {
+	case tokenLBrack:
+		return p.parseInterval(t)
+	case tokenNumber, tokenVar, tokenIdent, tokenMinus, tokenPlus:
+		p.unreadToken(t)
+		return p.parseArithCmp()
+	case tokenLParen:
+		// "(0, 6]" is an interval, and "(value+1)*2 <5" is an arithmetic operation rather than a group.
+		interval, err := p.isInterval()
+		if err != nil {
+			return nil, err
+		}
+		if interval {
+			return p.parseInterval(t)
+		}
+		arith, err := p.isArithParen()
+		if err != nil {
+			return nil, err
+		}
+		if arith {
+			p.unreadToken(t)
+			return p.parseArithCmp()
+		}
+
+		a, err := p.parseOrExpr()
+		if err != nil {
+			return nil, err
+		}
+		t1, err := p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if t1.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ')', but got %s", t1.text)
+		}
+		return &ParenExpr{Start: t.pos, Exprs: a}, nil
+	default:
+		return p.parseCmpExpr(t)
+	}
+}
+
+var assertions = map[string]Operator{
+	Integer.String():     Integer,
+	Finite.String():      Finite,
+	NotNaN.String():      NotNaN,
+	NonNegative.String(): NonNegative,
+	Safe.String():        Safe,
+}
+
+// parseArithCmp reads a range, or a comparison that has an arithmetic operation in the left-hand side.
+func (p *parser) parseArithCmp() (ExprNode, error) {
+	x, err := p.parseArith()
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := x.(*NumberLit); ok {
+		return p.parseRange(n)
+	}
+	if !refersValue(x) {
+		return nil, errors.New("the left-hand side of the comparison must refer to value")
+	}
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.parseCmpExpr(t)
+	if err != nil {
+		return nil, err
+	}
+	e.Start = x.Pos()
+	e.Left = x
+	return e, nil
+}
+
+func refersValue(e ExprNode) bool {
+	switch e := e.(type) {
+	case *ValueRef:
+		return true
+	case *ParenExpr:
+		return refersValue(e.Exprs[0])
+	case *UnaryExpr:
+		return refersValue(e.X)
+	case *CallExpr:
+		return refersValue(e.X)
+	case *BinaryExpr:
+		return refersValue(e.X) || refersValue(e.Y)
+	default:
+		return false
+	}
+}
+
+// isArithParen reports whether the following tokens until the matching ')' are an arithmetic operation.
+func (p *parser) isArithParen() (bool, error) {
+	var a []*token
+	defer func() {
+		for i := len(a) - 1; i >= 0; i-- {
+			p.unreadToken(a[i])
+		}
+	}()
+	depth := 1
+	for depth > 0 {
+		t, err := p.readToken()
+		if err != nil {
+			return false, err
+		}
+		a = append(a, t)
+		switch t.kind {
+		case tokenLParen:
+			depth++
+		case tokenRParen:
+			depth--
+		case tokenIdent, tokenNumber, tokenVar, tokenPlus, tokenMinus, tokenStar, tokenSlash, tokenPercent:
+		default:
+			return false, nil
 		}
-		metrics = append(metrics, &Metric{
-			Path:      a[0],
-			Value:     value,
-			Timestamp: tick,
-		})
-	}
-	if err := f.Err(); err != nil {
-		return nil, &ParseError{Line: lineno, Err: err}
 	}
-	return metrics, nil
+	return true, nil
 }
 
-// ReadRules reads r and returns rules.
-func ReadRules(r io.Reader) ([]*Rule, error) {
-	var rules []*Rule
-
-	f := bufio.NewReader(r)
-	for {
-		rule, err := parseRule(f)
+// isInterval reports whether the following tokens are "number ,".
+func (p *parser) isInterval() (bool, error) {
+	var a []*token
+	defer func() {
+		for i := len(a) - 1; i >= 0; i-- {
+			p.unreadToken(a[i])
+		}
+	}()
+	read := func() (*token, error) {
+		t, err := p.readToken()
 		if err != nil {
 			return nil, err
 		}
-		if rule == nil {
-			break
+		a = append(a, t)
+		return t, nil
+	}
+
+	t, err := read()
+	if err != nil {
+		return false, err
+	}
+	if t.kind == tokenMinus || t.kind == tokenPlus {
+		if t, err = read(); err != nil {
+			return false, err
 		}
-		rules = append(rules, rule)
 	}
-	return rules, nil
+	if t.kind != tokenNumber && t.kind != tokenVar {
+		return false, nil
+	}
+	if t, err = read(); err != nil {
+		return false, err
+	}
+	return t.kind == tokenComma, nil
 }
 
-type tokenKind int
+// parseRange reads the rest of a range such as "0..100" after low.
+func (p *parser) parseRange(low *NumberLit) (*RangeExpr, error) {
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenDotDot {
+		return nil, fmt.Errorf("expected '..' or a operator, but got %s", t.text)
+	}
+	high, err := p.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+	return &RangeExpr{Start: low.Start, Low: low, High: high}, nil
+}
 
-const (
-	tokenTilde tokenKind = iota
-	tokenLessThan
-	tokenLessEqual
-	tokenGreaterThan
-	tokenGreaterEqual
-	tokenText
-	tokenNumber
-	tokenComma
-	tokenNewline
-)
+// parseInterval reads an interval such as "[0, 6)" after the opening bracket t.
+func (p *parser) parseInterval(t *token) (*RangeExpr, error) {
+	low, err := p.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+	t1, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t1.kind != tokenComma {
+		return nil, fmt.Errorf("expected ',', but got %s", t1.text)
+	}
+	high, err := p.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+	t2, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t2.kind != tokenRBrack && t2.kind != tokenRParen {
+		return nil, fmt.Errorf("expected ']' or ')', but got %s", t2.text)
+	}
+	return &RangeExpr{
+		Start: t.pos,
+		Low:   low,
+		High:  high,
+		Open:  t.text,
+		Close: t2.text,
+	}, nil
+}
 
-type token struct {
-	kind tokenKind
-	text string
+func isEndOfStmt(t *token) bool {
+	switch t.kind {
+	case tokenNewline, tokenEOF, tokenComment, tokenSemicolon, tokenRBrace:
+		return true
+	default:
+		return false
+	}
 }
 
-func parseRule(r *bufio.Reader) (*Rule, error) {
-	rule := Rule{
-		Required: true,
+func (p *parser) parseCmpExpr(t *token) (*CmpExpr, error) {
+	var op Operator
+	switch t.kind {
+	default:
+		return nil, fmt.Errorf("expected a operator, but got %s", t.text)
+	case tokenLessThan:
+		op = LessThan
+	case tokenLessEqual:
+		op = LessEqual
+	case tokenGreaterThan:
+		op = GreaterThan
+	case tokenGreaterEqual:
+		op = GreaterEqual
+	case tokenEqual:
+		op = Equal
+	case tokenNotEqual:
+		op = NotEqual
+	}
+	n, err := p.parseNumber()
+	if err != nil {
+		return nil, err
 	}
+	return &CmpExpr{Start: t.pos, Op: op, X: n}, nil
+}
 
-	/*
-	 * metric path
-	 */
-	var peak *token
-	for { // skip blank lines
-		t, err := readToken(r)
+// parseArith reads an arithmetic operation such as "value/1024".
+func (p *parser) parseArith() (ExprNode, error) {
+	return p.parseBinary(precAdd)
+}
+
+func (p *parser) parseBinary(prec int) (ExprNode, error) {
+	if prec > precMul {
+		return p.parseFactor()
+	}
+	x, err := p.parseBinary(prec + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, err := p.readToken()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return nil, nil
-			}
 			return nil, err
 		}
-		if t.kind != tokenNewline {
-			peak = t
-			break
+		op, ok := arithOps[t.kind]
+		if !ok || arithPrec(op) != prec {
+			p.unreadToken(t)
+			return x, nil
 		}
-	}
-	t := peak
-	if t.kind == tokenTilde {
-		rule.Required = false
-		var err error
-		t, err = readToken(r)
+		y, err := p.parseBinary(prec + 1)
 		if err != nil {
-			return nil, fmt.Errorf("cannot read a path: %w", err)
+			return nil, err
 		}
+		x = &BinaryExpr{Start: x.Pos(), X: x, Op: op, Y: y}
 	}
-	if t.kind != tokenText {
-		return nil, fmt.Errorf("expected a path, but got %s", t.text)
-	}
-	rule.Path = t.text
+}
 
-	/*
-	 * expressions
-	 */
-	for {
-		t, err := readToken(r)
+var arithOps = map[tokenKind]string{
+	tokenPlus:    "+",
+	tokenMinus:   "-",
+	tokenStar:    "*",
+	tokenSlash:   "/",
+	tokenPercent: "%",
+}
+
+func (p *parser) parseFactor() (ExprNode, error) {
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case tokenNumber, tokenVar:
+		p.unreadToken(t)
+		return p.parseNumber()
+	case tokenMinus, tokenPlus:
+		t1, err := p.readToken()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
 			return nil, err
 		}
-		if t.kind == tokenNewline {
-			break
+		p.unreadToken(t1)
+		if t1.kind == tokenNumber {
+			p.unreadToken(t)
+			return p.parseNumber()
 		}
-		t1, err := readToken(r)
+		x, err := p.parseFactor()
 		if err != nil {
-			return nil, fmt.Errorf("cannot read a number: %w", err)
+			return nil, err
 		}
-		if t1.kind != tokenNumber {
-			return nil, fmt.Errorf("expected a number, but got %s", t.text)
+		return &UnaryExpr{Start: t.pos, Op: t.text, X: x}, nil
+	case tokenIdent:
+		if t.text == "value" {
+			return &ValueRef{Start: t.pos}, nil
 		}
-		n, err := strconv.ParseFloat(t1.text, 64)
+		if _, ok := valueFuncs[t.text]; !ok {
+			return nil, fmt.Errorf("unknown function %s", t.text)
+		}
+		x, err := p.parseParenArith()
 		if err != nil {
-			return nil, fmt.Errorf("cannot convert to a number: %w", err)
+			return nil, err
 		}
-		switch t.kind {
-		default:
-			return nil, fmt.Errorf("expected a operator, but got %s", t.text)
-		case tokenLessThan:
-			rule.Exprs = append(rule.Exprs, &Expr{Op: LessThan, Value: n})
-		case tokenLessEqual:
-			rule.Exprs = append(rule.Exprs, &Expr{Op: LessEqual, Value: n})
-		case tokenGreaterThan:
-			rule.Exprs = append(rule.Exprs, &Expr{Op: GreaterThan, Value: n})
-		case tokenGreaterEqual:
-			rule.Exprs = append(rule.Exprs, &Expr{Op: GreaterEqual, Value: n})
-		}
-
-		/*
-		 * comma or '\n'
-		 */
-		t, err = readToken(r)
+		return &CallExpr{Start: t.pos, Func: t.text, X: x}, nil
+	case tokenLParen:
+		p.unreadToken(t)
+		x, err := p.parseParenArith()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
 			return nil, err
 		}
-		if t.kind == tokenNewline {
-			break
+		return &ParenExpr{Start: t.pos, Exprs: []ExprNode{x}}, nil
+	default:
+		return nil, fmt.Errorf("expected a value, but got %s", t.text)
+	}
+}
+
+// parseParenArith reads an arithmetic operation in parentheses.
+func (p *parser) parseParenArith() (ExprNode, error) {
+	t, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenLParen {
+		return nil, fmt.Errorf("expected '(', but got %s", t.text)
+	}
+	x, err := p.parseArith()
+	if err != nil {
+		return nil, err
+	}
+	t, err = p.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenRParen {
+		return nil, fmt.Errorf("expected ')', but got %s", t.text)
+	}
+	return x, nil
+}
+
+// parseNumber reads a number literal or a variable.
+// In expressions, the sign is a separate token from the number.
+func (p *parser) parseNumber() (*NumberLit, error) {
+	t, err := p.readToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read a number: %w", err)
+	}
+	if t.kind == tokenMinus || t.kind == tokenPlus {
+		t1, err := p.readToken()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read a number: %w", err)
 		}
-		if t.kind != tokenComma {
-			return nil, fmt.Errorf("expected ',', but got %s", t.text)
+		if t1.kind != tokenNumber {
+			return nil, fmt.Errorf("expected a number, but got %s", t1.text)
+		}
+		t = &token{kind: tokenNumber, text: t.text + t1.text, pos: t.pos}
+	}
+	switch t.kind {
+	case tokenVar:
+		return &NumberLit{Start: t.pos, Var: t.text}, nil
+	case tokenNumber:
+		n, err := parseUnitNumber(t.text)
+		if err != nil {
+			return nil, err
 		}
+		return &NumberLit{Start: t.pos, Lit: t.text, Value: n}, nil
+	default:
+		return nil, fmt.Errorf("expected a number, but got %s", t.text)
+	}
+}
+
+// units are multipliers of unit suffixes.
+// Sizes are normalized to bytes, and durations are normalized to seconds.
+var units = map[string]float64{
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+	"ns":  1e-9,
+	"us":  1e-6,
+	"ms":  1e-3,
+	"s":   1,
+	"min": 60,
+	"h":   60 * 60,
+	"d":   24 * 60 * 60,
+	"%":   1,
+}
+
+// parseUnitNumber converts s such as "10KiB" or "1.5e3ms" to a number.
+func parseUnitNumber(s string) (float64, error) {
+	// the unit is the suffix; 'e' of the exponent is followed by digits.
+	i := strings.LastIndexFunc(s, func(c rune) bool { return !isUnitChar(c) }) + 1
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot convert to a number: %w", err)
 	}
-	return &rule, nil
+	if i == len(s) {
+		return n, nil
+	}
+	m, ok := units[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q in %s", s[i:], s)
+	}
+	return n * m, nil
+}
+
+func (p *parser) readRune() (rune, error) {
+	c, _, err := p.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	p.last = p.pos
+	if c == '\n' {
+		p.pos.Line++
+		p.pos.Col = 1
+	} else {
+		p.pos.Col++
+	}
+	return c, nil
+}
+
+func (p *parser) unreadRune() error {
+	if err := p.r.UnreadRune(); err != nil {
+		return err
+	}
+	p.pos = p.last
+	return nil
+}
+
+func (p *parser) unreadToken(t *token) {
+	p.peek = append(p.peek, t)
 }
 
-func readToken(r *bufio.Reader) (*token, error) {
-	if err := skipFunc(r, isSpace); err != nil {
+func (p *parser) readToken() (*token, error) {
+	if n := len(p.peek); n > 0 {
+		t := p.peek[n-1]
+		p.peek = p.peek[:n-1]
+		p.line = t.pos.Line
+		return t, nil
+	}
+	if err := p.skipFunc(isSpace); err != nil {
+		return nil, err
+	}
+	pos := p.pos
+	p.line = pos.Line
+	t, err := p.scanToken()
+	if err != nil {
 		return nil, err
 	}
-	c, _, err := r.ReadRune()
+	t.pos = pos
+	return t, nil
+}
+
+func (p *parser) scanToken() (*token, error) {
+	c, err := p.readRune()
 	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return &token{kind: tokenEOF, text: "EOF"}, nil
+		}
 		return nil, err
 	}
 	switch {
 	case c == '\n':
 		return &token{kind: tokenNewline, text: "\\n"}, nil
 	case c == '/':
-		c1, _, err := r.ReadRune()
+		if p.expr && !p.peekRune('/') {
+			return &token{kind: tokenSlash, text: "/"}, nil
+		}
+		c1, err := p.readRune()
 		if err != nil {
 			return nil, err
 		}
 		if c1 == '/' {
-			if err := skipFunc(r, isComment); err != nil {
-				return nil, err
+			t, err := p.readText(isComment, tokenComment)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					return nil, err
+				}
+				t = &token{kind: tokenComment}
 			}
-			return readToken(r)
+			t.text = "//" + t.text
+			return t, nil
 		}
 		return nil, errors.New("unexpected '/'")
 	case c == '~':
 		return &token{kind: tokenTilde, text: "~"}, nil
+	case c == '"':
+		return p.readString()
+	case c == '{':
+		return &token{kind: tokenLBrace, text: "{"}, nil
+	case c == '}':
+		return &token{kind: tokenRBrace, text: "}"}, nil
+	case c == '=' && p.expr && p.peekRune('='):
+		if _, err := p.readRune(); err != nil {
+			return nil, err
+		}
+		return &token{kind: tokenEqual, text: "=="}, nil
+	case c == '=':
+		return &token{kind: tokenAssign, text: "="}, nil
+	case c == '!' && p.expr:
+		if !p.peekRune('=') {
+			return nil, errors.New("unexpected '!'")
+		}
+		if _, err := p.readRune(); err != nil {
+			return nil, err
+		}
+		return &token{kind: tokenNotEqual, text: "!="}, nil
+	case c == '-' && p.expr:
+		return &token{kind: tokenMinus, text: "-"}, nil
+	case c == '+' && p.expr:
+		return &token{kind: tokenPlus, text: "+"}, nil
+	case c == '*' && p.expr:
+		return &token{kind: tokenStar, text: "*"}, nil
+	case c == '%' && p.expr:
+		return &token{kind: tokenPercent, text: "%"}, nil
+	case unicode.IsLetter(c) && p.labels:
+		if err := p.unreadRune(); err != nil {
+			return nil, err
+		}
+		return p.readText(isLabelChar, tokenLabel)
+	case (unicode.IsLetter(c) || c == '_') && p.expr:
+		if err := p.unreadRune(); err != nil {
+			return nil, err
+		}
+		return p.readText(isVarChar, tokenIdent)
+	case c == ';':
+		return &token{kind: tokenSemicolon, text: ";"}, nil
+	case c == '|':
+		return &token{kind: tokenBar, text: "|"}, nil
+	case c == '(':
+		return &token{kind: tokenLParen, text: "("}, nil
+	case c == ')':
+		return &token{kind: tokenRParen, text: ")"}, nil
+	case c == '[':
+		return &token{kind: tokenLBrack, text: "["}, nil
+	case c == ']':
+		return &token{kind: tokenRBrack, text: "]"}, nil
+	case c == '$':
+		t, err := p.readText(isVarChar, tokenVar)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if t == nil || t.text == "" {
+			return nil, errors.New("expected a variable name after '$'")
+		}
+		return t, nil
 	case c == '<':
-		c1, _, err := r.ReadRune()
+		c1, err := p.readRune()
 		if err != nil {
 			return nil, err
 		}
 		if c1 == '=' {
 			return &token{kind: tokenLessEqual, text: "<="}, nil
 		}
-		if err := r.UnreadRune(); err != nil {
+		if err := p.unreadRune(); err != nil {
 			return nil, err
 		}
 		return &token{kind: tokenLessThan, text: "<"}, nil
 	case c == '>':
-		c1, _, err := r.ReadRune()
+		c1, err := p.readRune()
 		if err != nil {
 			return nil, err
 		}
 		if c1 == '=' {
 			return &token{kind: tokenGreaterEqual, text: ">="}, nil
 		}
-		if err := r.UnreadRune(); err != nil {
+		if err := p.unreadRune(); err != nil {
 			return nil, err
 		}
 		return &token{kind: tokenGreaterThan, text: ">"}, nil
 	case c == ',':
 		return &token{kind: tokenComma, text: ","}, nil
-	case isNumber(c):
-		if err := r.UnreadRune(); err != nil {
+	case c == '-' || c == '+':
+		b, err := p.r.Peek(1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(b) > 0 && isNumber(rune(b[0])) {
+			return p.readNumber(string(c))
+		}
+		t, err := p.readWord()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			t = &token{kind: tokenText}
+		}
+		t.text = string(c) + t.text
+		return t, nil
+	case c == '.' && p.peekRune('.'):
+		if _, err := p.readRune(); err != nil {
 			return nil, err
 		}
-		return readText(r, isNumber, tokenNumber)
+		return &token{kind: tokenDotDot, text: ".."}, nil
+	case isNumber(c):
+		return p.readNumber(string(c))
 	default:
-		if err := r.UnreadRune(); err != nil {
+		if err := p.unreadRune(); err != nil {
+			return nil, err
+		}
+		return p.readWord()
+	}
+}
+
+// readWord reads a text such as a path.
+// The ';' separates statements only if it is followed by a space, '}' or the end of the file,
+// so that it does not split tagged paths such as "a.b;tag=x".
+// The '[' ends the text, so that labels can be written right after the path such as "a.b[linux]".
+func (p *parser) readWord() (*token, error) {
+	var w strings.Builder
+	for {
+		if b, _ := p.r.Peek(2); len(b) > 0 && b[0] == ';' && (len(b) == 1 || isWordEnd(b[1])) {
+			break
+		}
+		c, err := p.readRune()
+		if err != nil {
+			if errors.Is(err, io.EOF) && w.Len() > 0 {
+				return &token{kind: tokenText, text: w.String()}, nil
+			}
 			return nil, err
 		}
-		return readText(r, isText, tokenText)
+		if !isText(c) {
+			if err := p.unreadRune(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		w.WriteRune(c)
 	}
+	return &token{kind: tokenText, text: w.String()}, nil
+}
+
+func isWordEnd(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '}'
 }
 
-func readText(r *bufio.Reader, f func(c rune) bool, kind tokenKind) (*token, error) {
+func (p *parser) readText(f func(c rune) bool, kind tokenKind) (*token, error) {
 	var w strings.Builder
 	for {
-		c, _, err := r.ReadRune()
+		c, err := p.readRune()
 		if err != nil {
 			if errors.Is(err, io.EOF) && w.Len() > 0 {
 				return &token{kind: kind, text: w.String()}, nil
@@ -274,14 +1378,126 @@ func readText(r *bufio.Reader, f func(c rune) bool, kind tokenKind) (*token, err
 			return nil, err
 		}
 	}
-	if err := r.UnreadRune(); err != nil {
+	if err := p.unreadRune(); err != nil {
 		return nil, err
 	}
 	return &token{kind: kind, text: w.String()}, nil
 }
 
+// readNumber reads the rest of a number followed by an optional unit such as "10KiB".
+// It stops before ".." so that "0..100" is read as a range.
+func (p *parser) readNumber(prefix string) (*token, error) {
+	var w strings.Builder
+	w.WriteString(prefix)
+	unit := false
+	exp := false
+	for {
+		if b, err := p.r.Peek(2); err == nil && string(b) == ".." {
+			break
+		}
+		if p.peekModulo() {
+			break
+		}
+		c, err := p.readRune()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		ok := true
+		switch {
+		case !unit && !exp && (c == 'e' || c == 'E') && p.peekExponent():
+			exp = true
+			if b, _ := p.r.Peek(1); b[0] == '+' || b[0] == '-' {
+				w.WriteRune(c)
+				c, _ = p.readRune()
+			}
+		case !unit && isNumber(c):
+		case isUnitChar(c):
+			unit = true
+		default:
+			ok = false
+		}
+		if !ok {
+			if err := p.unreadRune(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		w.WriteRune(c)
+	}
+	return &token{kind: tokenNumber, text: w.String()}, nil
+}
+
+// peekRune reports whether the next rune is c.
+func (p *parser) peekRune(c byte) bool {
+	b, err := p.r.Peek(1)
+	return err == nil && b[0] == c
+}
+
+// peekExponent reports whether the next runes are digits of the exponent such as "3" and "-3".
+func (p *parser) peekExponent() bool {
+	b, _ := p.r.Peek(2)
+	if len(b) > 0 && (b[0] == '+' || b[0] == '-') {
+		b = b[1:]
+	}
+	return len(b) > 0 && b[0] >= '0' && b[0] <= '9'
+}
+
+// peekModulo reports whether the next '%' is followed by an operand such as "%7", "%$N" and "%(x)".
+// Then '%' is the modulo operator rather than the percent unit.
+func (p *parser) peekModulo() bool {
+	b, err := p.r.Peek(2)
+	if err != nil || b[0] != '%' {
+		return false
+	}
+	c := rune(b[1])
+	return isNumber(c) || unicode.IsLetter(c) || c == '_' || c == '$' || c == '('
+}
+
+func (p *parser) readString() (*token, error) {
+	var w strings.Builder
+	w.WriteRune('"')
+	escaped := false
+	for {
+		c, err := p.readRune()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("unterminated string")
+			}
+			return nil, err
+		}
+		if c == '\n' {
+			return nil, errors.New("unterminated string")
+		}
+		w.WriteRune(c)
+		if c == '"' && !escaped {
+			break
+		}
+		escaped = c == '\\' && !escaped
+	}
+	s, err := strconv.Unquote(w.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid string %s: %w", w.String(), err)
+	}
+	return &token{kind: tokenString, text: s}, nil
+}
+
 func isText(c rune) bool {
-	return !unicode.IsSpace(c)
+	return !unicode.IsSpace(c) && c != '{' && c != '}' && c != '['
+}
+
+func isUnitChar(c rune) bool {
+	return unicode.IsLetter(c) || c == '%'
+}
+
+func isVarChar(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isVarName(s string) bool {
+	return s != "" && !isNumber(rune(s[0])) && strings.IndexFunc(s, func(c rune) bool { return !isVarChar(c) }) < 0
 }
 
 func isNumber(c rune) bool {
@@ -296,9 +1512,9 @@ func isSpace(c rune) bool {
 	return unicode.IsSpace(c) && c != '\n'
 }
 
-func skipFunc(r *bufio.Reader, f func(c rune) bool) error {
+func (p *parser) skipFunc(f func(c rune) bool) error {
 	for {
-		c, _, err := r.ReadRune()
+		c, err := p.readRune()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				return nil
@@ -306,7 +1522,7 @@ func skipFunc(r *bufio.Reader, f func(c rune) bool) error {
 			return err
 		}
 		if !f(c) {
-			if err := r.UnreadRune(); err != nil {
+			if err := p.unreadRune(); err != nil {
 				return err
 			}
 			break
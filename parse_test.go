@@ -19,6 +19,12 @@ func TestParseError(t *testing.T) {
 	if err := e.Unwrap(); err != e.Err {
 		t.Errorf("Unwrap() = %v; want %v", err, e.Err)
 	}
+
+	e.File = "a.rules"
+	want = "parse error on a.rules:1: err"
+	if s := e.Error(); s != want {
+		t.Errorf("Error() = %q; want %q", s, want)
+	}
 }
 
 func TestReadMetrics(t *testing.T) {
@@ -68,6 +74,22 @@ func TestReadMetrics(t *testing.T) {
 	}
 }
 
+func TestNonFinite(t *testing.T) {
+	in := "a 1 0\nb NaN 0\nc +Inf 0\nd -Inf 0\ne 1e308 0\n"
+	metrics, err := ReadMetrics(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadMetrics(%q): %v", in, err)
+	}
+	var paths []string
+	for _, m := range NonFinite(metrics) {
+		paths = append(paths, m.Path)
+	}
+	want := []string{"b", "c", "d"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("NonFinite(%v) = %v; want %v", metrics, paths, want)
+	}
+}
+
 func TestReadMetrics_error(t *testing.T) {
 	tests := []string{
 		"a.b.c 0\n",              // 2 fields
@@ -137,6 +159,31 @@ func TestReadRules(t *testing.T) {
 				},
 			},
 		},
+		{
+			in: "a.b.c >-1, <+.5",
+			rules: []*Rule{
+				{
+					Required: true,
+					Path:     "a.b.c",
+					Exprs: []*Expr{
+						{Op: GreaterThan, Value: -1.0},
+						{Op: LessThan, Value: 0.5},
+					},
+				},
+			},
+		},
+		{
+			in: "include >0",
+			rules: []*Rule{
+				{
+					Required: true,
+					Path:     "include",
+					Exprs: []*Expr{
+						{Op: GreaterThan, Value: 0.0},
+					},
+				},
+			},
+		},
 		{
 			in: "//comment\na.b.c.xyz",
 			rules: []*Rule{
@@ -169,3 +216,588 @@ func TestReadRules(t *testing.T) {
 		}
 	}
 }
+
+func TestReadRules_tags(t *testing.T) {
+	in := "a.b;tag=x >0\nc;x=1;y=2; d;z=3 ==1;\ne;tag=y\n"
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	want := []string{"a.b;tag=x[>0]", "c;x=1;y=2[]", "d;z=3[==1]", "e;tag=y[]"}
+	if len(rules) != len(want) {
+		t.Fatalf("ReadRules(%q) = %v; want %v", in, rules, want)
+	}
+	for i, r := range rules {
+		if s := r.String(); s != want[i] {
+			t.Errorf("rules[%d] = %q; want %q", i, s, want[i])
+		}
+	}
+}
+
+func TestReadRules_prefix(t *testing.T) {
+	t.Setenv("GRAPHITEMETRICTEST_MAX", "100")
+	in := `let MAXCONN = 10000
+prefix custom.mysql { // mysql
+	conn <=$MAXCONN
+	prefix innodb {
+		let MIN = -1
+		~rows.read >$MIN, <= $GRAPHITEMETRICTEST_MAX
+	}
+}
+custom.x >=$MAXCONN
+`
+	want := []*Rule{
+		{
+			Required: true,
+			Path:     "custom.mysql.conn",
+			Exprs: []*Expr{
+				{Op: LessEqual, Value: 10000},
+			},
+		},
+		{
+			Required: false,
+			Path:     "custom.mysql.innodb.rows.read",
+			Exprs: []*Expr{
+				{Op: GreaterThan, Value: -1},
+				{Op: LessEqual, Value: 100},
+			},
+		},
+		{
+			Required: true,
+			Path:     "custom.x",
+			Exprs: []*Expr{
+				{Op: GreaterEqual, Value: 10000},
+			},
+		},
+	}
+	a, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ReadRules(%q) = %v; want %v", in, a, want)
+	}
+}
+
+func TestReadRules_error(t *testing.T) {
+	tests := []struct {
+		in   string
+		line int
+	}{
+		{in: "prefix a {\nb\n", line: 3},
+		{in: "a\n}\n", line: 2},
+		{in: "prefix a\nb\n", line: 1},
+		{in: "prefix a { b } c\n", line: 1},
+		{in: "use nic at a\n", line: 1},
+		{in: "template a {\n\tuse b at x\n}\ntemplate b { use a at y }\nuse a at z\n", line: 4},
+		{in: "template a { x }\ntemplate a { y }\n", line: 2},
+		{in: "use a b\n", line: 1},
+		{in: "a\nb <$UNDEFINED_VARIABLE_FOR_TEST\n", line: 2},
+		{in: "prefix a {\n\tlet X = 1\n}\nb <$X\n", line: 4},
+		{in: "let X = 1\nlet X = 2\n", line: 2},
+		{in: "let X-1 = 1\n", line: 1},
+		{in: "let X 1\n", line: 1},
+		{in: "a < $\n", line: 1},
+		{in: "a <0 |\n", line: 1},
+		{in: "a (<0 | >1\n", line: 1},
+		{in: "a <0 >1\n", line: 1},
+		{in: "a 0\n", line: 1},
+		{in: "a 0..\n", line: 1},
+		{in: "a [0, 1\n", line: 1},
+		{in: "a [0..1]\n", line: 1},
+		{in: "a <1kib\n", line: 1},
+		{in: "a <1..2\n", line: 1},
+		{in: "a value\n", line: 1},
+		{in: "a sqrt(value) <1\n", line: 1},
+		{in: "a value/ <1\n", line: 1},
+		{in: "a abs value <1\n", line: 1},
+		{in: "a (value+1 <1\n", line: 1},
+		{in: "a value = 1\n", line: 1},
+		{in: "a value ! 1\n", line: 1},
+		{in: "a 1+2 <1\n", line: 1},
+		{in: "when a ==1 b\n", line: 1},
+		{in: "when a ==1 require\n", line: 1},
+		{in: "atleast 3 {\n\ta\n\tb\n}\n", line: 1},
+		{in: "atleast 0 { a }\n", line: 1},
+		{in: "oneof {\n\tprefix x { a }\n}\n", line: 2},
+		{in: "@warn include \"a.rules\"\n", line: 1},
+		{in: "@warn @info a\n", line: 1},
+		{in: "a [linux\n", line: 1},
+		{in: "a [linux, 8x]\n", line: 1},
+		{in: "a [linux,]\n", line: 1},
+		{in: "a [linux mysql8]\n", line: 1},
+		{in: "a [linux] [mysql8]\n", line: 1},
+		{in: "-\n", line: 1},
+		{in: "-a >0\n", line: 1},
+		{in: "-a [linux]\n", line: 1},
+		{in: "when a require -b\n", line: 1},
+		{in: "@warn -a\n", line: 1},
+		{in: "oneof {\n\ta\n\t-b\n}\n", line: 3},
+	}
+	for _, tt := range tests {
+		_, err := ReadRules(strings.NewReader(tt.in))
+		if err == nil {
+			t.Errorf("ReadRules(%q) should return an error", tt.in)
+			continue
+		}
+		e, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("ReadRules(%q) = %T; want *ParseError", tt.in, err)
+			continue
+		}
+		if e.Line != tt.line {
+			t.Errorf("ReadRules(%q) = %v; want line %d", tt.in, err, tt.line)
+		}
+	}
+}
+
+func TestReadRules_template(t *testing.T) {
+	in := `let MIN = 0
+template nic { rx.bytes >=$MIN; ~tx.bytes >=$MIN }
+template disk {
+	let MIN = 1
+	use nic at io
+	reads >=$MIN
+}
+use nic at custom.interfaces.#
+prefix custom {
+	use disk at disks.#
+}
+`
+	want := []*Rule{
+		{Required: true, Path: "custom.interfaces.#.rx.bytes", Exprs: []*Expr{{Op: GreaterEqual, Value: 0}}},
+		{Required: false, Path: "custom.interfaces.#.tx.bytes", Exprs: []*Expr{{Op: GreaterEqual, Value: 0}}},
+		{Required: true, Path: "custom.disks.#.io.rx.bytes", Exprs: []*Expr{{Op: GreaterEqual, Value: 0}}},
+		{Required: false, Path: "custom.disks.#.io.tx.bytes", Exprs: []*Expr{{Op: GreaterEqual, Value: 0}}},
+		{Required: true, Path: "custom.disks.#.reads", Exprs: []*Expr{{Op: GreaterEqual, Value: 1}}},
+	}
+	a, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ReadRules(%q) = %v; want %v", in, a, want)
+	}
+}
+
+func TestReadRules_or(t *testing.T) {
+	alts := &Expr{Op: Or, Alts: [][]*Expr{
+		{{Op: LessThan, Value: 0}},
+		{{Op: GreaterEqual, Value: 3}, {Op: LessThan, Value: 5}},
+	}}
+	tests := []struct {
+		in    string
+		exprs []*Expr
+	}{
+		{
+			in:    "a.b <0 | >=3, <5\n",
+			exprs: []*Expr{alts},
+		},
+		{
+			in:    "a.b <0|>=3,<5,\n",
+			exprs: []*Expr{alts},
+		},
+		{
+			in:    "a.b >=-1, (<0 | >=3, <5) // comment\n",
+			exprs: []*Expr{{Op: GreaterEqual, Value: -1}, alts},
+		},
+		{
+			in:    "a.b (>=0, <1)\n",
+			exprs: []*Expr{{Op: GreaterEqual, Value: 0}, {Op: LessThan, Value: 1}},
+		},
+	}
+	for _, tt := range tests {
+		a, err := ReadRules(strings.NewReader(tt.in))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", tt.in, err)
+		}
+		want := []*Rule{{Required: true, Path: "a.b", Exprs: tt.exprs}}
+		if !reflect.DeepEqual(a, want) {
+			t.Errorf("ReadRules(%q) = %v; want %v", tt.in, a, want)
+		}
+	}
+}
+
+func TestReadRules_range(t *testing.T) {
+	tests := []struct {
+		in    string
+		exprs []*Expr
+	}{
+		{
+			in:    "a.b 0..100\n",
+			exprs: []*Expr{{Op: GreaterEqual, Value: 0}, {Op: LessEqual, Value: 100}},
+		},
+		{
+			in:    "a.b -1.5..$MAX\n",
+			exprs: []*Expr{{Op: GreaterEqual, Value: -1.5}, {Op: LessEqual, Value: 6}},
+		},
+		{
+			in:    "a.b [0, 6]\n",
+			exprs: []*Expr{{Op: GreaterEqual, Value: 0}, {Op: LessEqual, Value: 6}},
+		},
+		{
+			in:    "a.b (0,6]\n",
+			exprs: []*Expr{{Op: GreaterThan, Value: 0}, {Op: LessEqual, Value: 6}},
+		},
+		{
+			in:    "a.b [.5,$MAX)\n",
+			exprs: []*Expr{{Op: GreaterEqual, Value: 0.5}, {Op: LessThan, Value: 6}},
+		},
+		{
+			in: "a.b (0..1 | (2, 3))\n",
+			exprs: []*Expr{{Op: Or, Alts: [][]*Expr{
+				{{Op: GreaterEqual, Value: 0}, {Op: LessEqual, Value: 1}},
+				{{Op: GreaterThan, Value: 2}, {Op: LessThan, Value: 3}},
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		in := "let MAX = 6\n" + tt.in
+		a, err := ReadRules(strings.NewReader(in))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", in, err)
+		}
+		want := []*Rule{{Required: true, Path: "a.b", Exprs: tt.exprs}}
+		if !reflect.DeepEqual(a, want) {
+			t.Errorf("ReadRules(%q) = %v; want %v", in, a, want)
+		}
+	}
+}
+
+func TestReadRules_unit(t *testing.T) {
+	tests := []struct {
+		in    string
+		value float64
+	}{
+		{in: "a <=1073741824", value: 1 << 30},
+		{in: "a <=1GiB", value: 1 << 30},
+		{in: "a <=10KiB", value: 10 * 1024},
+		{in: "a <=5MB", value: 5e6},
+		{in: "a <=2TB", value: 2e12},
+		{in: "a <=512B", value: 512},
+		{in: "a <=250ms", value: 0.25},
+		{in: "a <=1.5h", value: 5400},
+		{in: "a <=2min", value: 120},
+		{in: "a <=90%", value: 90},
+		{in: "a >=-1KB", value: -1000},
+		{in: "let X = 4MiB\na <=$X", value: 4 << 20},
+	}
+	for _, tt := range tests {
+		a, err := ReadRules(strings.NewReader(tt.in))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", tt.in, err)
+		}
+		if len(a) != 1 || len(a[0].Exprs) != 1 {
+			t.Fatalf("ReadRules(%q) = %v; want a rule", tt.in, a)
+		}
+		if v := a[0].Exprs[0].Value; v != tt.value {
+			t.Errorf("ReadRules(%q) = %g; want %g", tt.in, v, tt.value)
+		}
+	}
+}
+
+func TestReadRules_arith(t *testing.T) {
+	in := `let MAX = 6
+prefix value { abs // a path named by the keyword
+	x value/1024 <100, >=0
+	y value % 512 ==0 // comment
+}
+z !=0; w ==1
+`
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	want := []string{
+		"value.abs[]",
+		"value.x[value/1024<100,>=0]",
+		"value.y[value % 512==0]",
+		"z[!=0]",
+		"w[==1]",
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ReadRules(%q) = %v; want %v", in, rules, want)
+	}
+	for i, r := range rules {
+		if s := r.String(); s != want[i] {
+			t.Errorf("rules[%d] = %q; want %q", i, s, want[i])
+		}
+	}
+
+	tests := []struct {
+		value float64
+		valid []bool
+	}{
+		{value: 0, valid: []bool{true, true, true, false, false}},
+		{value: 1, valid: []bool{true, true, false, true, true}},
+		{value: 1024, valid: []bool{true, true, true, true, false}},
+		{value: 102400, valid: []bool{true, false, true, true, false}},
+	}
+	for _, tt := range tests {
+		for i, r := range rules {
+			if v := r.IsValid(tt.value); v != tt.valid[i] {
+				t.Errorf("%v.IsValid(%g) = %t; want %t", r, tt.value, v, tt.valid[i])
+			}
+		}
+	}
+}
+
+func TestReadRules_modulo(t *testing.T) {
+	tests := []struct {
+		in    string
+		value float64
+		valid bool
+	}{
+		{in: "a value*100%7 ==0", value: 7, valid: true},
+		{in: "a value*100%7 ==0", value: 5, valid: false},
+		{in: "a value%(2+1) ==0", value: 9, valid: true},
+		{in: "let N = 4\na value%$N ==0", value: 6, valid: false},
+		{in: "a value%value ==0", value: 3, valid: true},
+		{in: "a value >=50%", value: 50, valid: true},
+	}
+	for _, tt := range tests {
+		a, err := ReadRules(strings.NewReader(tt.in))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", tt.in, err)
+		}
+		if len(a) != 1 {
+			t.Fatalf("ReadRules(%q) = %v; want a rule", tt.in, a)
+		}
+		if v := a[0].IsValid(tt.value); v != tt.valid {
+			t.Errorf("%v.IsValid(%g) = %t; want %t", a[0], tt.value, v, tt.valid)
+		}
+	}
+}
+
+func TestReadRules_exponent(t *testing.T) {
+	tests := []struct {
+		in    string
+		value float64
+		valid bool
+	}{
+		{in: "a <1e3", value: 999, valid: true},
+		{in: "a <1e3", value: 1000, valid: false},
+		{in: "a >=1.5E+2", value: 150, valid: true},
+		{in: "a <5e-3", value: 0.004, valid: true},
+		{in: "a <1e3KB", value: 999999, valid: true},
+		{in: "a <1e3KB", value: 1000000, valid: false},
+		{in: "a 1e2..2e2", value: 200, valid: true},
+		{in: "a value*1e2 <5", value: 0.04, valid: true},
+	}
+	for _, tt := range tests {
+		a, err := ReadRules(strings.NewReader(tt.in))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", tt.in, err)
+		}
+		if len(a) != 1 {
+			t.Fatalf("ReadRules(%q) = %v; want a rule", tt.in, a)
+		}
+		if v := a[0].IsValid(tt.value); v != tt.valid {
+			t.Errorf("%v.IsValid(%g) = %t; want %t", a[0], tt.value, v, tt.valid)
+		}
+	}
+}
+
+func TestReadRules_assertion(t *testing.T) {
+	in := "a int, nonneg\nb finite | notnan, safe\nc value/2 int\n"
+	_, err := ReadRules(strings.NewReader(in))
+	if err == nil {
+		t.Fatalf("ReadRules(%q) should return an error", in)
+	}
+
+	in = "a int, nonneg\nb finite | notnan, safe\n"
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	want := []*Rule{
+		{Required: true, Path: "a", Exprs: []*Expr{{Op: Integer}, {Op: NonNegative}}},
+		{Required: true, Path: "b", Exprs: []*Expr{{Op: Or, Alts: [][]*Expr{
+			{{Op: Finite}},
+			{{Op: NotNaN}, {Op: Safe}},
+		}}}},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("ReadRules(%q) = %v; want %v", in, rules, want)
+	}
+}
+
+func TestReadRules_when(t *testing.T) {
+	in := `prefix custom.redis {
+	when replication.role ==1 require replication.lag >=0 // comment
+	when replication.role require ~replication.offset
+}
+`
+	want := []*Rule{
+		{
+			Required: true,
+			Path:     "custom.redis.replication.lag",
+			Exprs:    []*Expr{{Op: GreaterEqual, Value: 0}},
+			When: &Condition{
+				Path:  "custom.redis.replication.role",
+				Exprs: []*Expr{{Op: Equal, Value: 1}},
+			},
+		},
+		{
+			Required: false,
+			Path:     "custom.redis.replication.offset",
+			When:     &Condition{Path: "custom.redis.replication.role"},
+		},
+	}
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("ReadRules(%q) = %v; want %v", in, rules, want)
+	}
+}
+
+func TestReadRules_group(t *testing.T) {
+	in := `prefix a {
+	oneof { // exclusive
+		ipv4.* >=0
+		~ipv6.*
+	}
+	atleast 2 { b; c; d }
+}
+`
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	want := []string{"~a.ipv4.*[>=0]", "~a.ipv6.*[]", "~a.b[]", "~a.c[]", "~a.d[]"}
+	if len(rules) != len(want) {
+		t.Fatalf("ReadRules(%q) = %v; want %v", in, rules, want)
+	}
+	for i, r := range rules {
+		if s := r.String(); s != want[i] {
+			t.Errorf("rules[%d] = %q; want %q", i, s, want[i])
+		}
+	}
+	groups := []string{"oneof{a.ipv4.*,a.ipv6.*}", "atleast 2{a.b,a.c,a.d}"}
+	for i, g := range groupsOf(rules) {
+		if s := g.String(); s != groups[i] {
+			t.Errorf("groups[%d] = %q; want %q", i, s, groups[i])
+		}
+	}
+}
+
+func TestReadRules_severity(t *testing.T) {
+	in := `@warn a >0
+@info when a require b
+@warn oneof {
+	c
+	@error d
+}
+e
+`
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	want := []Severity{SeverityWarning, SeverityInfo, SeverityWarning, SeverityError, SeverityError}
+	if len(rules) != len(want) {
+		t.Fatalf("ReadRules(%q) = %v; want %d rules", in, rules, len(want))
+	}
+	for i, r := range rules {
+		if r.Severity != want[i] {
+			t.Errorf("rules[%d].Severity = %v; want %v", i, r.Severity, want[i])
+		}
+	}
+	if g := rules[2].Group; g.Severity != SeverityWarning {
+		t.Errorf("the group severity = %v; want %v", g.Severity, SeverityWarning)
+	}
+}
+
+func TestReadRules_annotation(t *testing.T) {
+	in := `// @desc the number of slow queries
+// per second
+// @desc in the last minute
+// @owner dba
+// @link https://example.com/a
+// @link https://example.com/b
+a >0
+
+// @owner net
+oneof {
+	b
+	// @owner ipv6
+	c
+}
+// @desc not applied
+
+d
+`
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	want := []*Rule{
+		{
+			Description: "the number of slow queries\nin the last minute",
+			Owner:       "dba",
+			Links:       []string{"https://example.com/a", "https://example.com/b"},
+		},
+		{Owner: "net"},
+		{Owner: "ipv6"},
+		{},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ReadRules(%q) = %v; want %d rules", in, rules, len(want))
+	}
+	for i, r := range rules {
+		if r.Description != want[i].Description {
+			t.Errorf("rules[%d].Description = %q; want %q", i, r.Description, want[i].Description)
+		}
+		if r.Owner != want[i].Owner {
+			t.Errorf("rules[%d].Owner = %q; want %q", i, r.Owner, want[i].Owner)
+		}
+		if !reflect.DeepEqual(r.Links, want[i].Links) {
+			t.Errorf("rules[%d].Links = %q; want %q", i, r.Links, want[i].Links)
+		}
+	}
+}
+
+func TestReadRules_labels(t *testing.T) {
+	t.Setenv("LOW", "2")
+	in := `a [linux, mysql8] >0
+b [0, 1)
+~c [container]
+when a require d [linux] [0, 1]
+e [ x86-64 ,linux.6 ]
+include [linux] >0 // a path named by the keyword
+f [ $LOW, 1]
+g.h[linux] >0
+i.j[0, 1)
+`
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	want := []struct {
+		s      string
+		labels []string
+	}{
+		{s: "a[>0]", labels: []string{"linux", "mysql8"}},
+		{s: "b[>=0,<1]"},
+		{s: "~c[]", labels: []string{"container"}},
+		{s: "d[>=0,<=1] when a[]", labels: []string{"linux"}},
+		{s: "e[]", labels: []string{"x86-64", "linux.6"}},
+		{s: "include[>0]", labels: []string{"linux"}},
+		{s: "f[>=2,<=1]"},
+		{s: "g.h[>0]", labels: []string{"linux"}},
+		{s: "i.j[>=0,<1]"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ReadRules(%q) = %v; want %d rules", in, rules, len(want))
+	}
+	for i, r := range rules {
+		if s := r.String(); s != want[i].s {
+			t.Errorf("rules[%d] = %q; want %q", i, s, want[i].s)
+		}
+		if !reflect.DeepEqual(r.Labels, want[i].labels) {
+			t.Errorf("rules[%d].Labels = %q; want %q", i, r.Labels, want[i].labels)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package graphitemetrictest
 
 import (
 	"fmt"
+	"math"
 	"strings"
 )
 
@@ -22,8 +23,21 @@ const (
 	LessEqual
 	GreaterThan
 	GreaterEqual
+	Or // any one of Expr.Alts is satisfied.
+	Equal
+	NotEqual
+
+	// Assertions of the value; Expr.Value is not used.
+	Integer     // an integer.
+	Finite      // neither NaN nor infinity.
+	NotNaN      // not NaN.
+	NonNegative // zero or positive.
+	Safe        // not greater than 2^53 in absolute value, where integers are exact in float64; not only integers.
 )
 
+// maxSafeInteger is the largest integer n such that n and n+1 are exactly represented in float64.
+const maxSafeInteger = 1 << 53
+
 // String returns the representation of the operator.
 func (op Operator) String() string {
 	switch op {
@@ -35,18 +49,46 @@ func (op Operator) String() string {
 		return ">"
 	case GreaterEqual:
 		return ">="
+	case Or:
+		return "|"
+	case Equal:
+		return "=="
+	case NotEqual:
+		return "!="
+	case Integer:
+		return "int"
+	case Finite:
+		return "finite"
+	case NotNaN:
+		return "notnan"
+	case NonNegative:
+		return "nonneg"
+	case Safe:
+		return "safe"
 	default:
 		panic("unknown operator")
 	}
 }
 
+func (op Operator) isAssertion() bool {
+	return op >= Integer && op <= Safe
+}
+
 // Expr represents a expression.
+//
+// If X is not nil, X derived from the metric value is compared with Value instead of the metric value.
+// If Op is Or, the expression is satisfied when all expressions in any one of Alts are satisfied.
 type Expr struct {
 	Op    Operator
 	Value float64
+	X     ValueExpr
+	Alts  [][]*Expr // alternatives of Or.
 }
 
 func (e *Expr) isValid(value float64) bool {
+	if e.X != nil && e.Op != Or {
+		value = e.X.Eval(value)
+	}
 	switch e.Op {
 	case LessThan:
 		return value < e.Value
@@ -56,49 +98,188 @@ func (e *Expr) isValid(value float64) bool {
 		return value > e.Value
 	case GreaterEqual:
 		return value >= e.Value
+	case Equal:
+		return value == e.Value
+	case NotEqual:
+		return value != e.Value
+	case Integer:
+		return value == math.Trunc(value) && !math.IsInf(value, 0)
+	case Finite:
+		return !math.IsNaN(value) && !math.IsInf(value, 0)
+	case NotNaN:
+		return !math.IsNaN(value)
+	case NonNegative:
+		return value >= 0
+	case Safe:
+		return math.Abs(value) <= maxSafeInteger
+	case Or:
+		for _, exprs := range e.Alts {
+			if isValidAll(exprs, value) {
+				return true
+			}
+		}
+		return false
 	default:
 		panic("unknown operator")
 	}
 }
 
+func isValidAll(exprs []*Expr, value float64) bool {
+	for _, e := range exprs {
+		if !e.isValid(value) {
+			return false
+		}
+	}
+	return true
+}
+
 // String returns the representation of the expression.
 func (e *Expr) String() string {
+	if e.Op == Or {
+		alts := make([]string, len(e.Alts))
+		for i, exprs := range e.Alts {
+			alts[i] = joinExprs(exprs)
+		}
+		return "(" + strings.Join(alts, "|") + ")"
+	}
+	if e.Op.isAssertion() {
+		return e.Op.String()
+	}
+	if e.X != nil {
+		return fmt.Sprintf("%v%v%g", e.X, e.Op, e.Value)
+	}
 	return fmt.Sprintf("%v%g", e.Op, e.Value)
 }
 
+func joinExprs(exprs []*Expr) string {
+	a := make([]string, len(exprs))
+	for i, e := range exprs {
+		a[i] = e.String()
+	}
+	return strings.Join(a, ",")
+}
+
 // Rule represents a rule for matching each lines in the protocol message.
 //
 // BUGS(lufia): currently Path does not support tags syntax.
 type Rule struct {
-	Required bool    // whether a rule should match to the message at least once.
-	Path     string  // dot separated path; it can be contained some wildcards (* or #).
-	Exprs    []*Expr // if Exprs is empty, that rule only checks the path exists.
+	Required    bool       // whether a rule should match to the message at least once.
+	Path        string     // dot separated path; it can be contained some wildcards (* or #).
+	Exprs       []*Expr    // if Exprs is empty, that rule only checks the path exists.
+	Description string     // human readable description of the metric.
+	Owner       string     // owner of the metric such as a team name.
+	Links       []string   // URLs of documents such as runbooks.
+	Labels      []string   // labels for selecting rules; see Selector.
+	Removed     bool       // if Removed is true, the rule removes rules of the same path in Merge.
+	When        *Condition // if When is not nil, the rule is required only if When holds.
+	Group       *Group     // if Group is not nil, the number of matched rules in Group is checked.
+	Severity    Severity   // severity of the invalid data reported by the rule.
 }
 
 // String returns the string representation of the rule.
 func (r *Rule) String() string {
-	exprs := make([]string, len(r.Exprs))
-	for i, e := range r.Exprs {
-		exprs[i] = e.String()
-	}
-
 	flag := ""
-	if !r.Required {
+	switch {
+	case r.Removed:
+		flag = "-"
+	case !r.Required:
 		flag = "~"
 	}
-	return fmt.Sprintf("%s%s[%v]", flag, r.Path, strings.Join(exprs, ","))
+	s := fmt.Sprintf("%s%s[%v]", flag, r.Path, joinExprs(r.Exprs))
+	if r.When != nil {
+		s += " when " + r.When.String()
+	}
+	return s
 }
 
-// IsValid returns true if all expression are passed.
-func (r *Rule) IsValid(value float64) bool {
-	for _, e := range r.Exprs {
-		if !e.isValid(value) {
+func (r *Rule) isRequired(metrics []*Metric) bool {
+	return r.Required && (r.When == nil || r.When.holds(metrics))
+}
+
+// Group represents a group of rules that the number of matched rules is limited.
+// A rule in the group is matched if the value of any metric matched to the rule satisfies its expressions;
+// neither metrics that violate the rule nor metrics matched to other rules of the same path count.
+type Group struct {
+	Min      int
+	Max      int // if Max is zero, the number of matched rules is unlimited.
+	Rules    []*Rule
+	Severity Severity
+}
+
+// OneOf returns a group that exactly one of rules is matched.
+func OneOf(rules ...*Rule) *Group {
+	return newGroup(1, 1, rules)
+}
+
+// AtLeast returns a group that at least n of rules are matched.
+func AtLeast(n int, rules ...*Rule) *Group {
+	return newGroup(n, 0, rules)
+}
+
+func newGroup(min, max int, rules []*Rule) *Group {
+	g := &Group{Min: min, Max: max, Rules: rules}
+	for _, r := range rules {
+		r.Group = g
+	}
+	return g
+}
+
+// String returns the string representation of the group.
+func (g *Group) String() string {
+	paths := make([]string, len(g.Rules))
+	for i, r := range g.Rules {
+		paths[i] = r.Path
+	}
+	s := "{" + strings.Join(paths, ",") + "}"
+	if g.Min == 1 && g.Max == 1 {
+		return "oneof" + s
+	}
+	return fmt.Sprintf("atleast %d%s", g.Min, s)
+}
+
+func (g *Group) isValid(n int) bool {
+	return n >= g.Min && (g.Max == 0 || n <= g.Max)
+}
+
+// Condition represents a condition of the rule.
+// It holds if any metric matched to Path satisfies all of Exprs.
+type Condition struct {
+	Path  string
+	Exprs []*Expr
+}
+
+// String returns the string representation of the condition.
+func (c *Condition) String() string {
+	return fmt.Sprintf("%s[%v]", c.Path, joinExprs(c.Exprs))
+}
+
+func (c *Condition) holds(metrics []*Metric) bool {
+	p := splitMetricName(c.Path)
+	for _, m := range metrics {
+		if matchPath(p, splitMetricName(m.Path)) && isValidAll(c.Exprs, m.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(pattern, p []string) bool {
+	if len(pattern) != len(p) {
+		return false
+	}
+	for i, s := range pattern {
+		if s != anyChar && s != p[i] {
 			return false
 		}
 	}
 	return true
 }
 
+// IsValid returns true if all expression are passed.
+func (r *Rule) IsValid(value float64) bool {
+	return isValidAll(r.Exprs, value)
+}
+
 // Metric represents a metric of the protocol.
 type Metric struct {
 	Path      string
@@ -116,17 +297,28 @@ func (m *Metric) String() string {
 // If Rule is not nil and Metric is not nil, the metric is violated for a rule's expression.
 // If Rule is not nil and Metric is nil, the metric is needed but it is not found.
 // If Rule is nil and Metric is not nil, the metric was not matched any rules.
+// If Group is not nil, the number of matched rules in the group, Count, is out of range.
+//
+// Severity is the severity of the rule or the group. It is SeverityError for unexpected metrics.
+//
+// For the unexpected metric, Suggestion is the nearest rule if any.
+// For the missing required rule, Renamed is the unexpected metric that the path differs by one segment;
+// the metric is likely renamed from the rule's path.
 type InvalidData struct {
-	Rule   *Rule
-	Metric *Metric
+	Rule       *Rule
+	Metric     *Metric
+	Group      *Group
+	Count      int
+	Severity   Severity
+	Suggestion *Rule
+	Renamed    *Metric
 }
 
 type ruleMap struct {
 	tree map[string]*ruleMap
 
-	rules    []*Rule
-	required bool
-	used     int
+	rules []*Rule
+	used  int
 }
 
 func (m *ruleMap) isLeaf() bool {
@@ -172,9 +364,16 @@ func (m *ruleMap) addRule(p []string, r *Rule) {
 		m = v
 	}
 	m.rules = append(m.rules, r)
-	if r.Required {
-		m.required = true
+}
+
+// isRequired returns true if any one of the rules is required with metrics.
+func (m *ruleMap) isRequired(metrics []*Metric) bool {
+	for _, r := range m.rules {
+		if r.isRequired(metrics) {
+			return true
+		}
 	}
+	return false
 }
 
 func (m *ruleMap) lookupPath(p []string) *ruleMap {
@@ -191,9 +390,13 @@ func (m *ruleMap) lookupPath(p []string) *ruleMap {
 	return m
 }
 
+// makeRules returns the tree of rules; removed rules are ignored.
 func makeRules(rules []*Rule) *ruleMap {
 	var m ruleMap
 	for _, r := range rules {
+		if r.Removed {
+			continue
+		}
 		p := splitMetricName(r.Path)
 		m.addRule(p, r)
 	}
@@ -211,10 +414,14 @@ func splitMetricName(s string) []string {
 }
 
 // Diff checks validity of rules and metrics and returns any invalid data.
+// Conditions of rules are evaluated against metrics.
+// Removed rules are ignored; use Merge to apply them to other rules.
 func Diff(rules []*Rule, metrics []*Metric) []*InvalidData {
 	var results []*InvalidData
 
+	rules = dropRemoved(rules)
 	m := makeRules(rules)
+	matched := make(map[*Rule]bool) // rules in groups matched to valid values.
 	for _, c := range metrics {
 		p := splitMetricName(c.Path)
 		v := m.lookupPath(p)
@@ -223,19 +430,49 @@ func Diff(rules []*Rule, metrics []*Metric) []*InvalidData {
 			continue
 		}
 		v.used++
+		for _, r := range v.rules {
+			if r.Group != nil && r.IsValid(c.Value) {
+				matched[r] = true
+			}
+		}
 		if !v.isValid(c.Value) {
 			for _, r := range v.rules {
-				results = append(results, &InvalidData{Rule: r, Metric: c})
+				results = append(results, &InvalidData{Rule: r, Metric: c, Severity: r.Severity})
 			}
 			continue
 		}
 	}
 	for _, l := range m.leaves() {
-		if l.required && l.used == 0 {
+		if l.used == 0 && l.isRequired(metrics) {
 			for _, r := range l.rules {
-				results = append(results, &InvalidData{Rule: r})
+				results = append(results, &InvalidData{Rule: r, Severity: r.Severity})
 			}
 		}
 	}
+	for _, g := range groupsOf(rules) {
+		n := 0
+		for _, r := range g.Rules {
+			if matched[r] {
+				n++
+			}
+		}
+		if !g.isValid(n) {
+			results = append(results, &InvalidData{Group: g, Count: n, Severity: g.Severity})
+		}
+	}
+	suggest(rules, results)
 	return results
 }
+
+// groupsOf returns groups of rules in order of appearance.
+func groupsOf(rules []*Rule) []*Group {
+	var groups []*Group
+	seen := make(map[*Group]bool)
+	for _, r := range rules {
+		if r.Group != nil && !seen[r.Group] {
+			seen[r.Group] = true
+			groups = append(groups, r.Group)
+		}
+	}
+	return groups
+}
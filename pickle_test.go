@@ -2,7 +2,9 @@ package graphitemetrictest
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,6 +50,21 @@ func TestRule_String(t *testing.T) {
 			},
 			s: "a.b.c[<3,<=2.15,>0,>=-3]",
 		},
+		{
+			name: "or",
+			rule: &Rule{
+				Required: true,
+				Path:     "a.b.c",
+				Exprs: []*Expr{
+					{Op: GreaterEqual, Value: -1.0},
+					{Op: Or, Alts: [][]*Expr{
+						{{Op: LessThan, Value: 0.0}},
+						{{Op: GreaterEqual, Value: 3.0}, {Op: LessThan, Value: 5.0}},
+					}},
+				},
+			},
+			s: "a.b.c[>=-1,(<0|>=3,<5)]",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -100,6 +117,26 @@ func TestDiff_expr(t *testing.T) {
 			value: 3.0,
 			valid: true,
 		},
+		{
+			exprs: []*Expr{
+				{Op: Or, Alts: [][]*Expr{
+					{{Op: LessThan, Value: 0.0}},
+					{{Op: GreaterEqual, Value: 3.0}, {Op: LessThan, Value: 5.0}},
+				}},
+			},
+			value: 4.0,
+			valid: true,
+		},
+		{
+			exprs: []*Expr{
+				{Op: Or, Alts: [][]*Expr{
+					{{Op: LessThan, Value: 0.0}},
+					{{Op: GreaterEqual, Value: 3.0}, {Op: LessThan, Value: 5.0}},
+				}},
+			},
+			value: 5.0,
+			valid: false,
+		},
 	}
 	for _, tt := range tests {
 		rule := &Rule{
@@ -187,15 +224,37 @@ func TestDiff_path(t *testing.T) {
 							{Op: LessEqual, Value: 3.0},
 						},
 					},
+					Renamed: &Metric{Path: "custom.metric1.value1", Value: 3.0},
 				},
 				{
 					Metric: &Metric{Path: "custom.metric1.value1", Value: 3.0},
+					Suggestion: &Rule{
+						Required: true,
+						Path:     "custom.metric1.value",
+						Exprs: []*Expr{
+							{Op: LessEqual, Value: 3.0},
+						},
+					},
 				},
 				{
 					Metric: &Metric{Path: "custom.metric2.value", Value: 3.0},
+					Suggestion: &Rule{
+						Required: true,
+						Path:     "custom.metric1.value",
+						Exprs: []*Expr{
+							{Op: LessEqual, Value: 3.0},
+						},
+					},
 				},
 				{
 					Metric: &Metric{Path: "custom.metric1", Value: 3.0},
+					Suggestion: &Rule{
+						Required: true,
+						Path:     "custom.metric1.value",
+						Exprs: []*Expr{
+							{Op: LessEqual, Value: 3.0},
+						},
+					},
 				},
 				{
 					Rule: &Rule{
@@ -346,3 +405,258 @@ func (p *InvalidData) String() string {
 	}
 	return s
 }
+
+func TestExpr_assertion(t *testing.T) {
+	tests := []struct {
+		op      Operator
+		valid   []float64
+		invalid []float64
+	}{
+		{
+			op:      Integer,
+			valid:   []float64{0, -3, 1.2e19},
+			invalid: []float64{0.5, math.NaN(), math.Inf(1)},
+		},
+		{
+			op:      Finite,
+			valid:   []float64{0, -0.5, math.MaxFloat64},
+			invalid: []float64{math.NaN(), math.Inf(1), math.Inf(-1)},
+		},
+		{
+			op:      NotNaN,
+			valid:   []float64{0, math.Inf(1)},
+			invalid: []float64{math.NaN()},
+		},
+		{
+			op:      NonNegative,
+			valid:   []float64{0, 0.1, math.Inf(1)},
+			invalid: []float64{-0.1, math.NaN(), math.Inf(-1)},
+		},
+		{
+			op:      Safe,
+			valid:   []float64{0, 0.5, 1 << 53, -(1 << 53)},
+			invalid: []float64{1<<53 + 2, 1.2e19, math.NaN(), math.Inf(1)},
+		},
+	}
+	for _, tt := range tests {
+		e := &Expr{Op: tt.op}
+		for _, v := range tt.valid {
+			if !e.isValid(v) {
+				t.Errorf("%v.isValid(%g) = false; want true", e, v)
+			}
+		}
+		for _, v := range tt.invalid {
+			if e.isValid(v) {
+				t.Errorf("%v.isValid(%g) = true; want false", e, v)
+			}
+		}
+	}
+}
+
+func TestDiff_when(t *testing.T) {
+	rules := []*Rule{
+		{
+			Required: true,
+			Path:     "custom.redis.replication.role",
+		},
+		{
+			Required: true,
+			Path:     "custom.redis.replication.lag",
+			When: &Condition{
+				Path:  "custom.redis.replication.role",
+				Exprs: []*Expr{{Op: Equal, Value: 1}},
+			},
+		},
+		{
+			Required: true,
+			Path:     "custom.disks.#.errors",
+			When:     &Condition{Path: "custom.disks.#.reads"},
+		},
+	}
+	tests := []struct {
+		name    string
+		metrics []*Metric
+		missing []string
+	}{
+		{
+			name: "not satisfied",
+			metrics: []*Metric{
+				{Path: "custom.redis.replication.role", Value: 0},
+			},
+			missing: nil,
+		},
+		{
+			name: "satisfied",
+			metrics: []*Metric{
+				{Path: "custom.redis.replication.role", Value: 1},
+				{Path: "custom.disks.sda.reads", Value: 1},
+			},
+			missing: []string{"custom.redis.replication.lag", "custom.disks.#.errors"},
+		},
+		{
+			name: "matched",
+			metrics: []*Metric{
+				{Path: "custom.redis.replication.role", Value: 1},
+				{Path: "custom.redis.replication.lag", Value: 0},
+				{Path: "custom.disks.sda.reads", Value: 1},
+				{Path: "custom.disks.sda.errors", Value: 0},
+			},
+			missing: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var missing []string
+			for _, v := range Diff(rules, tt.metrics) {
+				if v.Metric != nil {
+					continue // unexpected metrics such as reads are not interested here.
+				}
+				missing = append(missing, v.Rule.Path)
+			}
+			if !sameStrings(missing, tt.missing) {
+				t.Errorf("Diff(%v) = %v; want %v", tt.metrics, missing, tt.missing)
+			}
+		})
+	}
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]int)
+	for _, s := range a {
+		m[s]++
+	}
+	for _, s := range b {
+		m[s]--
+	}
+	for _, n := range m {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiff_group(t *testing.T) {
+	tests := []struct {
+		name    string
+		group   *Group
+		metrics []string
+		count   int
+		valid   bool
+	}{
+		{
+			name:    "oneof/none",
+			group:   OneOf(&Rule{Path: "a.ipv4.*"}, &Rule{Path: "a.ipv6.*"}),
+			metrics: nil,
+			count:   0,
+			valid:   false,
+		},
+		{
+			name:    "oneof/one",
+			group:   OneOf(&Rule{Path: "a.ipv4.*"}, &Rule{Path: "a.ipv6.*"}),
+			metrics: []string{"a.ipv4.rx", "a.ipv4.tx"},
+			valid:   true,
+		},
+		{
+			name:    "oneof/both",
+			group:   OneOf(&Rule{Path: "a.ipv4.*"}, &Rule{Path: "a.ipv6.*"}),
+			metrics: []string{"a.ipv4.rx", "a.ipv6.rx"},
+			count:   2,
+			valid:   false,
+		},
+		{
+			name:    "atleast/short",
+			group:   AtLeast(2, &Rule{Path: "a.b"}, &Rule{Path: "a.c"}, &Rule{Path: "a.d"}),
+			metrics: []string{"a.c"},
+			count:   1,
+			valid:   false,
+		},
+		{
+			name:    "atleast/enough",
+			group:   AtLeast(2, &Rule{Path: "a.b"}, &Rule{Path: "a.c"}, &Rule{Path: "a.d"}),
+			metrics: []string{"a.b", "a.c", "a.d"},
+			valid:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var metrics []*Metric
+			for _, s := range tt.metrics {
+				metrics = append(metrics, &Metric{Path: s})
+			}
+			a := Diff(tt.group.Rules, metrics)
+			if tt.valid {
+				if len(a) != 0 {
+					t.Errorf("Diff(%v, %v) = %v; want nothing", tt.group, tt.metrics, a)
+				}
+				return
+			}
+			want := []*InvalidData{{Group: tt.group, Count: tt.count}}
+			if !reflect.DeepEqual(a, want) {
+				t.Errorf("Diff(%v, %v) = %v; want %v", tt.group, tt.metrics, a, want)
+			}
+		})
+	}
+}
+
+func TestDiff_groupInvalid(t *testing.T) {
+	g := OneOf(&Rule{Path: "a.x", Exprs: []*Expr{{Op: GreaterThan, Value: 0}}}, &Rule{Path: "a.y"})
+	other := &Rule{Path: "a.x"}
+	metric := &Metric{Path: "a.x", Value: -1}
+
+	// the metric violates the rule in the group, so the group is not matched.
+	a := Diff(g.Rules, []*Metric{metric})
+	want := []*InvalidData{
+		{Rule: g.Rules[0], Metric: metric},
+		{Group: g, Count: 0},
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Diff(%v, %v) = %v; want %v", g.Rules, metric, a, want)
+	}
+
+	// the other rule of the same path satisfies the metric, but it is not in the group.
+	rules := append([]*Rule{other}, g.Rules...)
+	a = Diff(rules, []*Metric{metric})
+	want = []*InvalidData{{Group: g, Count: 0}}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Diff(%v, %v) = %v; want %v", rules, metric, a, want)
+	}
+}
+
+func TestDiff_removed(t *testing.T) {
+	rules, err := ReadRules(strings.NewReader("-a\n~b\n"))
+	if err != nil {
+		t.Fatalf("ReadRules: %v", err)
+	}
+	metrics := []*Metric{{Path: "b"}}
+	if a := Diff(rules, metrics); len(a) != 0 {
+		t.Errorf("Diff(%v, %v) = %v; want nothing", rules, metrics, a)
+	}
+}
+
+func TestDiff_severity(t *testing.T) {
+	rules := []*Rule{
+		{Path: "a.b", Exprs: []*Expr{{Op: GreaterThan, Value: 0}}, Severity: SeverityWarning},
+		{Required: true, Path: "a.c", Severity: SeverityInfo},
+	}
+	g := OneOf(&Rule{Path: "a.d"}, &Rule{Path: "a.e"})
+	g.Severity = SeverityWarning
+	rules = append(rules, g.Rules...)
+	metrics := []*Metric{
+		{Path: "a.b", Value: 0},
+		{Path: "a.x", Value: 1},
+	}
+	want := []*InvalidData{
+		{Rule: rules[0], Metric: metrics[0], Severity: SeverityWarning},
+		{Metric: metrics[1], Severity: SeverityError, Suggestion: rules[1]},
+		{Rule: rules[1], Severity: SeverityInfo, Renamed: metrics[1]},
+		{Group: g, Severity: SeverityWarning},
+	}
+	a := Diff(rules, metrics)
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Diff(%v, %v) = %v; want %v", rules, metrics, a, want)
+	}
+}
@@ -0,0 +1,583 @@
+package graphitemetrictest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// maxPickleSize is the maximum length of a payload; it is same as carbon's default.
+const maxPickleSize = 1 << 20
+
+// Pickle opcodes that carbon clients emit.
+const (
+	opMark           = '('
+	opStop           = '.'
+	opPop            = '0'
+	opPopMark        = '1'
+	opDup            = '2'
+	opFloat          = 'F'
+	opInt            = 'I'
+	opBinInt         = 'J'
+	opBinInt1        = 'K'
+	opLong           = 'L'
+	opBinInt2        = 'M'
+	opNone           = 'N'
+	opString         = 'S'
+	opBinString      = 'T'
+	opShortBinString = 'U'
+	opUnicode        = 'V'
+	opBinUnicode     = 'X'
+	opAppend         = 'a'
+	opBinGet         = 'h'
+	opLongBinGet     = 'j'
+	opGet            = 'g'
+	opList           = 'l'
+	opEmptyList      = ']'
+	opAppends        = 'e'
+	opPut            = 'p'
+	opBinPut         = 'q'
+	opLongBinPut     = 'r'
+	opTuple          = 't'
+	opEmptyTuple     = ')'
+	opBinFloat       = 'G'
+	opBinBytes       = 'B'
+	opShortBinBytes  = 'C'
+
+	// protocol 2
+	opProto    = '\x80'
+	opTuple1   = '\x85'
+	opTuple2   = '\x86'
+	opTuple3   = '\x87'
+	opNewTrue  = '\x88'
+	opNewFalse = '\x89'
+	opLong1    = '\x8a'
+	opLong4    = '\x8b'
+
+	// protocol 4
+	opShortBinUnicode = '\x8c'
+	opBinUnicode8     = '\x8d'
+	opBinBytes8       = '\x8e'
+	opMemoize         = '\x94'
+	opFrame           = '\x95'
+)
+
+type pickleTuple []interface{}
+
+type pickleList struct {
+	a []interface{}
+}
+
+type pickleMark struct{}
+
+// ReadPickle reads r in the pickle protocol and returns metrics.
+//
+// The stream is a sequence of payloads; each payload is prefixed with its length
+// in 4 bytes big-endian, and is a pickled list of (path, (timestamp, value)) tuples.
+func ReadPickle(r io.Reader) ([]*Metric, error) {
+	var metrics []*Metric
+
+	f := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		var size uint32
+		if err := binary.Read(f, binary.BigEndian, &size); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("payload %d: cannot read the length: %w", n, err)
+		}
+		if size > maxPickleSize {
+			return nil, fmt.Errorf("payload %d: length %d exceeds %d bytes", n, size, maxPickleSize)
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return nil, fmt.Errorf("payload %d: %w", n, err)
+		}
+		a, err := decodePickle(buf)
+		if err != nil {
+			return nil, fmt.Errorf("payload %d: %w", n, err)
+		}
+		metrics = append(metrics, a...)
+	}
+	return metrics, nil
+}
+
+func decodePickle(buf []byte) ([]*Metric, error) {
+	v, err := unpickle(bufio.NewReader(bytes.NewReader(buf)))
+	if err != nil {
+		return nil, err
+	}
+	var a []interface{}
+	switch v := v.(type) {
+	case *pickleList:
+		a = v.a
+	case pickleTuple:
+		a = v
+	default:
+		return nil, fmt.Errorf("expected a list, but got %T", v)
+	}
+	metrics := make([]*Metric, len(a))
+	for i, v := range a {
+		m, err := pickledMetric(v)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		metrics[i] = m
+	}
+	return metrics, nil
+}
+
+var errPickledMetric = errors.New("expected (path, (timestamp, value))")
+
+func pickledMetric(v interface{}) (*Metric, error) {
+	t, ok := v.(pickleTuple)
+	if !ok || len(t) != 2 {
+		return nil, errPickledMetric
+	}
+	path, ok := t[0].(string)
+	if !ok {
+		return nil, errPickledMetric
+	}
+	t, ok = t[1].(pickleTuple)
+	if !ok || len(t) != 2 {
+		return nil, errPickledMetric
+	}
+	var tick int64
+	switch v := t[0].(type) {
+	case int64:
+		tick = v
+	case float64:
+		tick = int64(v)
+	default:
+		return nil, errPickledMetric
+	}
+	value, ok := pickledNumber(t[1])
+	if !ok {
+		return nil, errPickledMetric
+	}
+	return &Metric{
+		Path:      path,
+		Value:     value,
+		Timestamp: tick,
+	}, nil
+}
+
+func pickledNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func unpickle(r *bufio.Reader) (interface{}, error) {
+	var (
+		stack []interface{}
+		memo  = make(map[int]interface{})
+	)
+	push := func(v interface{}) {
+		stack = append(stack, v)
+	}
+	pop := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, errors.New("stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	popMark := func() ([]interface{}, error) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if _, ok := stack[i].(pickleMark); ok {
+				a := append([]interface{}(nil), stack[i+1:]...)
+				stack = stack[:i]
+				return a, nil
+			}
+		}
+		return nil, errors.New("mark not found")
+	}
+	popN := func(n int) ([]interface{}, error) {
+		if len(stack) < n {
+			return nil, errors.New("stack underflow")
+		}
+		a := append([]interface{}(nil), stack[len(stack)-n:]...)
+		stack = stack[:len(stack)-n]
+		return a, nil
+	}
+	top := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, errors.New("stack underflow")
+		}
+		return stack[len(stack)-1], nil
+	}
+	appendTo := func(a []interface{}) error {
+		v, err := top()
+		if err != nil {
+			return err
+		}
+		l, ok := v.(*pickleList)
+		if !ok {
+			return fmt.Errorf("cannot append to %T", v)
+		}
+		l.a = append(l.a, a...)
+		return nil
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		switch op {
+		default:
+			return nil, fmt.Errorf("unsupported opcode %#x", op)
+		case opStop:
+			return pop()
+		case opProto:
+			if _, err := r.ReadByte(); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+		case opFrame:
+			if _, err := readBytes(r, 8); err != nil {
+				return nil, err
+			}
+		case opMark:
+			push(pickleMark{})
+		case opPop:
+			if _, err := pop(); err != nil {
+				return nil, err
+			}
+		case opPopMark:
+			if _, err := popMark(); err != nil {
+				return nil, err
+			}
+		case opDup:
+			v, err := top()
+			if err != nil {
+				return nil, err
+			}
+			push(v)
+		case opNone:
+			push(nil)
+		case opNewTrue:
+			push(true)
+		case opNewFalse:
+			push(false)
+		case opInt:
+			s, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			switch s {
+			case "00":
+				push(false)
+			case "01":
+				push(true)
+			default:
+				n, err := strconv.ParseInt(s, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				push(n)
+			}
+		case opLong:
+			s, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			if len(s) > 0 && s[len(s)-1] == 'L' {
+				s = s[:len(s)-1]
+			}
+			n, ok := new(big.Int).SetString(s, 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid long %q", s)
+			}
+			push(normalizeInt(n))
+		case opBinInt:
+			b, err := readBytes(r, 4)
+			if err != nil {
+				return nil, err
+			}
+			push(int64(int32(binary.LittleEndian.Uint32(b))))
+		case opBinInt1:
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			push(int64(c))
+		case opBinInt2:
+			b, err := readBytes(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			push(int64(binary.LittleEndian.Uint16(b)))
+		case opLong1, opLong4:
+			var n int
+			if op == opLong1 {
+				n, err = readSize(r, 1)
+			} else {
+				n, err = readSize(r, 4)
+			}
+			if err != nil {
+				return nil, err
+			}
+			b, err := readBytes(r, n)
+			if err != nil {
+				return nil, err
+			}
+			push(decodeLong(b))
+		case opFloat:
+			s, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, err
+			}
+			push(f)
+		case opBinFloat:
+			b, err := readBytes(r, 8)
+			if err != nil {
+				return nil, err
+			}
+			push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case opString:
+			s, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+				s = `"` + s[1:len(s)-1] + `"`
+			}
+			s, err = strconv.Unquote(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string: %w", err)
+			}
+			push(s)
+		case opUnicode:
+			s, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			push(s)
+		case opShortBinString, opShortBinBytes, opShortBinUnicode,
+			opBinString, opBinBytes, opBinUnicode,
+			opBinBytes8, opBinUnicode8:
+			var n int
+			switch op {
+			case opShortBinString, opShortBinBytes, opShortBinUnicode:
+				n, err = readSize(r, 1)
+			case opBinString, opBinBytes, opBinUnicode:
+				n, err = readSize(r, 4)
+			default:
+				n, err = readSize(r, 8)
+			}
+			if err != nil {
+				return nil, err
+			}
+			b, err := readBytes(r, n)
+			if err != nil {
+				return nil, err
+			}
+			push(string(b))
+		case opEmptyList:
+			push(&pickleList{})
+		case opList:
+			a, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			push(&pickleList{a: a})
+		case opAppend:
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendTo([]interface{}{v}); err != nil {
+				return nil, err
+			}
+		case opAppends:
+			a, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := appendTo(a); err != nil {
+				return nil, err
+			}
+		case opEmptyTuple:
+			push(pickleTuple{})
+		case opTuple:
+			a, err := popMark()
+			if err != nil {
+				return nil, err
+			}
+			push(pickleTuple(a))
+		case opTuple1, opTuple2, opTuple3:
+			a, err := popN(int(op-opTuple1) + 1)
+			if err != nil {
+				return nil, err
+			}
+			push(pickleTuple(a))
+		case opPut, opBinPut, opLongBinPut, opMemoize:
+			var i int
+			switch op {
+			case opPut:
+				i, err = readIndex(r)
+			case opBinPut:
+				i, err = readSize(r, 1)
+			case opLongBinPut:
+				i, err = readSize(r, 4)
+			default:
+				i = len(memo)
+			}
+			if err != nil {
+				return nil, err
+			}
+			v, err := top()
+			if err != nil {
+				return nil, err
+			}
+			memo[i] = v
+		case opGet, opBinGet, opLongBinGet:
+			var i int
+			switch op {
+			case opGet:
+				i, err = readIndex(r)
+			case opBinGet:
+				i, err = readSize(r, 1)
+			default:
+				i, err = readSize(r, 4)
+			}
+			if err != nil {
+				return nil, err
+			}
+			v, ok := memo[i]
+			if !ok {
+				return nil, fmt.Errorf("memo %d not found", i)
+			}
+			push(v)
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	s, err := r.ReadString('\n')
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return s[:len(s)-1], nil
+}
+
+func readBytes(r *bufio.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+// readSize reads an unsigned little-endian integer of n bytes.
+func readSize(r *bufio.Reader, n int) (int, error) {
+	b, err := readBytes(r, n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	if v > maxPickleSize {
+		return 0, fmt.Errorf("size %d exceeds %d bytes", v, maxPickleSize)
+	}
+	return int(v), nil
+}
+
+func readIndex(r *bufio.Reader) (int, error) {
+	s, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+// decodeLong decodes a little-endian two's complement integer.
+func decodeLong(b []byte) interface{} {
+	be := make([]byte, len(b))
+	for i, c := range b {
+		be[len(b)-1-i] = c
+	}
+	n := new(big.Int).SetBytes(be)
+	if len(b) > 0 && b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return normalizeInt(n)
+}
+
+func normalizeInt(n *big.Int) interface{} {
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	return n
+}
+
+// WritePickle writes metrics to w as a payload of the pickle protocol.
+func WritePickle(w io.Writer, metrics []*Metric) error {
+	buf := make([]byte, 4, 4+64*len(metrics))
+	buf = append(buf, opProto, 2, opEmptyList)
+	if len(metrics) > 0 {
+		buf = append(buf, opMark)
+		for _, m := range metrics {
+			if m.Path == "" {
+				return errPath
+			}
+			buf = append(buf, opBinUnicode)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Path)))
+			buf = append(buf, m.Path...)
+			if m.Timestamp >= math.MinInt32 && m.Timestamp <= math.MaxInt32 {
+				buf = append(buf, opBinInt)
+				buf = binary.LittleEndian.AppendUint32(buf, uint32(m.Timestamp))
+			} else {
+				buf = append(buf, opLong1, 8)
+				buf = binary.LittleEndian.AppendUint64(buf, uint64(m.Timestamp))
+			}
+			buf = append(buf, opBinFloat)
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(m.Value))
+			buf = append(buf, opTuple2, opTuple2)
+		}
+		buf = append(buf, opAppends)
+	}
+	buf = append(buf, opStop)
+
+	size := len(buf) - 4
+	if size > maxPickleSize {
+		return fmt.Errorf("payload length %d exceeds %d bytes", size, maxPickleSize)
+	}
+	binary.BigEndian.PutUint32(buf, uint32(size))
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,120 @@
+package graphitemetrictest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// payload returns s prefixed with its length.
+func payload(s string) string {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(s)))
+	return string(b) + s
+}
+
+func TestReadPickle(t *testing.T) {
+	metrics := []*Metric{
+		{Path: "a.b.c", Value: 1.5, Timestamp: 1623988183},
+		{Path: "a.b.d", Value: 3, Timestamp: 1623988183},
+		{Path: "a.b.c", Value: math.Pow(2, 70), Timestamp: 1623988184},
+	}
+	tests := []struct {
+		name    string
+		in      string
+		metrics []*Metric
+	}{
+		{
+			name:    "protocol 0",
+			in:      payload("(lp0\n(Va.b.c\np1\n(I1623988183\nF1.5\ntp2\ntp3\na(Va.b.d\np4\n(F1623988183.0\nI3\ntp5\ntp6\na(g1\n(I1623988184\nL1180591620717411303424L\ntp7\ntp8\na."),
+			metrics: metrics,
+		},
+		{
+			name:    "protocol 2",
+			in:      payload("\x80\x02]q\x00(X\x05\x00\x00\x00a.b.cq\x01J\xd7\x17\xcc`G?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x05\x00\x00\x00a.b.dq\x04GA\xd83\x05\xf5\xc0\x00\x00K\x03\x86q\x05\x86q\x06h\x01J\xd8\x17\xcc`\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@\x86q\x07\x86q\x08e."),
+			metrics: metrics,
+		},
+		{
+			name:    "protocol 4",
+			in:      payload("\x80\x04\x95L\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x05a.b.c\x94J\xd7\x17\xcc`G?\xf8\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x05a.b.d\x94GA\xd83\x05\xf5\xc0\x00\x00K\x03\x86\x94\x86\x94h\x01J\xd8\x17\xcc`\x8a\t\x00\x00\x00\x00\x00\x00\x00\x00@\x86\x94\x86\x94e."),
+			metrics: metrics,
+		},
+		{
+			name: "python2 strings",
+			in:   payload("(lp0\n(S'a.b.c'\np1\n(I1623988183\nF1.5\ntp2\ntp3\na."),
+			metrics: []*Metric{
+				{Path: "a.b.c", Value: 1.5, Timestamp: 1623988183},
+			},
+		},
+		{
+			name: "multiple payloads",
+			in:   payload("\x80\x02](X\x01\x00\x00\x00aK\x01K\x02\x86\x86e.") + payload("\x80\x02].") + payload("\x80\x02](X\x01\x00\x00\x00bK\x03K\x04\x86\x86e."),
+			metrics: []*Metric{
+				{Path: "a", Value: 2, Timestamp: 1},
+				{Path: "b", Value: 4, Timestamp: 3},
+			},
+		},
+		{
+			name:    "empty",
+			in:      "",
+			metrics: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ReadPickle(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("ReadPickle(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(a, tt.metrics) {
+				t.Errorf("ReadPickle(%q) = %v; want %v", tt.in, a, tt.metrics)
+			}
+		})
+	}
+}
+
+func TestReadPickle_error(t *testing.T) {
+	tests := []string{
+		"\x00\x00",                   // truncated length
+		"\x00\x00\x00\x05\x80\x02]",  // truncated payload
+		payload("\x80\x02]"),         // without STOP
+		payload("\x80\x02N."),        // not a list
+		payload("\x80\x02](K\x01e."), // not a tuple
+		payload("\x80\x02](X\x01\x00\x00\x00aK\x01\x86e."), // not a pair
+		payload("\x80\x02}."),                              // unsupported opcode
+		"\x7f\xff\xff\xff",                                 // too large
+	}
+	for _, tt := range tests {
+		_, err := ReadPickle(strings.NewReader(tt))
+		if err == nil {
+			t.Errorf("ReadPickle(%q) should return an error", tt)
+		}
+	}
+}
+
+func TestWritePickle(t *testing.T) {
+	tests := [][]*Metric{
+		nil,
+		{
+			{Path: "a.b.c", Value: 1.5, Timestamp: 1623988183},
+			{Path: "a.b.c;tag=x", Value: -3, Timestamp: -1},
+			{Path: "a.b.d", Value: math.Inf(1), Timestamp: 1 << 40},
+			{Path: "a.b.d", Value: 0, Timestamp: math.MinInt64},
+		},
+	}
+	for _, metrics := range tests {
+		var buf bytes.Buffer
+		if err := WritePickle(&buf, metrics); err != nil {
+			t.Fatalf("WritePickle: %v", err)
+		}
+		a, err := ReadPickle(&buf)
+		if err != nil {
+			t.Fatalf("ReadPickle: %v", err)
+		}
+		if !reflect.DeepEqual(a, metrics) {
+			t.Errorf("ReadPickle(WritePickle(%v)) = %v", metrics, a)
+		}
+	}
+}
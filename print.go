@@ -0,0 +1,241 @@
+package graphitemetrictest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Fprint writes f to w in the canonical format.
+//
+// Consecutive blank lines are reduced to one, and expressions and trailing comments
+// are aligned in columns within each blocks. Statements in the prefix block are indented by a tab.
+func Fprint(w io.Writer, f *File) error {
+	b := bufio.NewWriter(w)
+	printStmts(b, f.Stmts, "")
+	return b.Flush()
+}
+
+func printStmts(w *bufio.Writer, stmts []Stmt, indent string) {
+	for i, block := range splitBlocks(stmts) {
+		if i > 0 {
+			w.WriteString("\n")
+		}
+		printBlock(w, block, indent)
+	}
+}
+
+// splitBlocks splits stmts into non-empty blocks separated by blank lines.
+func splitBlocks(stmts []Stmt) [][]Stmt {
+	var (
+		blocks [][]Stmt
+		block  []Stmt
+	)
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*BlankLine); ok {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+			}
+			block = nil
+			continue
+		}
+		block = append(block, stmt)
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+type ruleLine struct {
+	path    string
+	exprs   string
+	comment string
+}
+
+func printBlock(w *bufio.Writer, block []Stmt, indent string) {
+	lines := make(map[*RuleStmt]*ruleLine)
+	var w1, w2 int
+	for _, stmt := range block {
+		if s, ok := stmt.(*RuleStmt); ok {
+			l := formatRuleStmt(s)
+			if l.exprs != "" || l.comment != "" {
+				w1 = maxInt(w1, utf8.RuneCountInString(l.path))
+			}
+			if l.comment != "" {
+				w2 = maxInt(w2, utf8.RuneCountInString(l.exprs))
+			}
+			lines[s] = l
+		}
+	}
+	for _, stmt := range block {
+		w.WriteString(indent)
+		switch s := stmt.(type) {
+		case *Comment:
+			w.WriteString(s.Text)
+		case *IncludeStmt:
+			w.WriteString("include " + strconv.Quote(s.Pattern))
+			printComment(w, s.Comment)
+		case *LetStmt:
+			w.WriteString("let " + s.Name + " = " + formatNumber(s.Value))
+			printComment(w, s.Comment)
+		case *PrefixBlock:
+			w.WriteString("prefix " + s.Prefix)
+			printBraces(w, s.Comment, s.Stmts, s.EndComment, indent)
+		case *TemplateBlock:
+			w.WriteString("template " + s.Name)
+			printBraces(w, s.Comment, s.Stmts, s.EndComment, indent)
+		case *GroupBlock:
+			w.WriteString(formatSeverity(s.Severity))
+			if s.Min == 1 && s.Max == 1 {
+				w.WriteString("oneof")
+			} else {
+				w.WriteString("atleast " + strconv.Itoa(s.Min))
+			}
+			printBraces(w, s.Comment, s.Stmts, s.EndComment, indent)
+		case *UseStmt:
+			w.WriteString("use " + s.Name + " at " + s.Path)
+			printComment(w, s.Comment)
+		case *WhenStmt:
+			w.WriteString(formatSeverity(s.Severity) + "when " + s.Path)
+			if len(s.Exprs) > 0 {
+				w.WriteString(" " + formatExprs(s.Exprs))
+			}
+			l := formatRuleStmt(s.Rule)
+			w.WriteString(" require " + l.path)
+			if l.exprs != "" {
+				w.WriteString(" " + l.exprs)
+			}
+			if l.comment != "" {
+				w.WriteString(" " + l.comment)
+			}
+		case *RuleStmt:
+			l := lines[s]
+			var buf strings.Builder
+			buf.WriteString(l.path)
+			if l.exprs != "" || l.comment != "" {
+				pad(&buf, w1-utf8.RuneCountInString(l.path)+1)
+				buf.WriteString(l.exprs)
+			}
+			if l.comment != "" {
+				if w2 > 0 {
+					pad(&buf, w2-utf8.RuneCountInString(l.exprs)+1)
+				}
+				buf.WriteString(l.comment)
+			}
+			w.WriteString(strings.TrimRight(buf.String(), " "))
+		default:
+			panic(fmt.Sprintf("unknown statement %T", s))
+		}
+		w.WriteString("\n")
+	}
+}
+
+func printBraces(w *bufio.Writer, c *Comment, stmts []Stmt, endComment *Comment, indent string) {
+	w.WriteString(" {")
+	printComment(w, c)
+	w.WriteString("\n")
+	printStmts(w, stmts, indent+"\t")
+	w.WriteString(indent + "}")
+	printComment(w, endComment)
+}
+
+func printComment(w *bufio.Writer, c *Comment) {
+	if c != nil {
+		w.WriteString(" " + c.Text)
+	}
+}
+
+func pad(w *strings.Builder, n int) {
+	w.WriteString(strings.Repeat(" ", n))
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func formatRuleStmt(s *RuleStmt) *ruleLine {
+	var l ruleLine
+	l.path = formatSeverity(s.Severity)
+	if s.Optional {
+		l.path += "~"
+	}
+	if s.Removed {
+		l.path += "-"
+	}
+	l.path += s.Path
+	if len(s.Labels) > 0 {
+		l.path += " [" + strings.Join(s.Labels, ",") + "]"
+	}
+	l.exprs = formatExprs(s.Exprs)
+	if s.Comment != nil {
+		l.comment = s.Comment.Text
+	}
+	return &l
+}
+
+func formatSeverity(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "@" + s + " "
+}
+
+func formatExprs(exprs []ExprNode) string {
+	a := make([]string, len(exprs))
+	for i, e := range exprs {
+		a[i] = formatExpr(e)
+	}
+	return strings.Join(a, ", ")
+}
+
+func formatExpr(e ExprNode) string {
+	switch e := e.(type) {
+	case *CmpExpr:
+		s := e.Op.String() + formatNumber(e.X)
+		if e.Left != nil {
+			s = formatExpr(e.Left) + " " + s
+		}
+		return s
+	case *AssertExpr:
+		return e.Op.String()
+	case *NumberLit:
+		return formatNumber(e)
+	case *ValueRef:
+		return "value"
+	case *CallExpr:
+		return e.Func + "(" + formatExpr(e.X) + ")"
+	case *UnaryExpr:
+		return e.Op + formatExpr(e.X)
+	case *BinaryExpr:
+		return formatExpr(e.X) + formatArithOp(e.Op) + formatExpr(e.Y)
+	case *OrExpr:
+		alts := make([]string, len(e.Alts))
+		for i, exprs := range e.Alts {
+			alts[i] = formatExprs(exprs)
+		}
+		return strings.Join(alts, " | ")
+	case *ParenExpr:
+		return "(" + formatExprs(e.Exprs) + ")"
+	case *RangeExpr:
+		if e.Open == "" {
+			return formatNumber(e.Low) + ".." + formatNumber(e.High)
+		}
+		return e.Open + formatNumber(e.Low) + ", " + formatNumber(e.High) + e.Close
+	default:
+		panic(fmt.Sprintf("unknown expression %T", e))
+	}
+}
+
+func formatNumber(n *NumberLit) string {
+	if n.Var != "" {
+		return "$" + n.Var
+	}
+	return n.Lit
+}
@@ -0,0 +1,127 @@
+package graphitemetrictest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "align",
+			in: `// comment
+local.random.diceroll	>0,<=6	 // v > 0 && v <= 6
+~local.network.tx.bytes >0 // optional
+local.uptime
+local.thermal.*.temp <=100000
+`,
+			want: `// comment
+local.random.diceroll   >0, <=6 // v > 0 && v <= 6
+~local.network.tx.bytes >0      // optional
+local.uptime
+local.thermal.*.temp    <=100000
+`,
+		},
+		{
+			name: "blank lines",
+			in: `
+
+a.b	>.5
+
+  
+b.c >= 1
+// end
+`,
+			want: `a.b >.5
+
+b.c >=1
+// end
+`,
+		},
+		{
+			name: "or",
+			in:   "a.b <0|>=3,<5\nc (>0 |<-1),<= 5 // x\n",
+			want: "a.b <0 | >=3, <5\nc   (>0 | <-1), <=5 // x\n",
+		},
+		{
+			name: "range",
+			in:   "a 0..100\nb [0,6) // x\nc (1KiB,  $MAX]\n",
+			want: "a 0..100\nb [0, 6) // x\nc (1KiB, $MAX]\n",
+		},
+		{
+			name: "arith",
+			in:   "a value/1024<100\nb abs( value - 1 )  <5\nc value%512 == 0 // x\n",
+			want: "a value/1024 <100\nb abs(value-1) <5\nc value % 512 ==0 // x\n",
+		},
+		{
+			name: "assertion",
+			in:   "a int,nonneg\nb  safe|>=0\n",
+			want: "a int, nonneg\nb safe | >=0\n",
+		},
+		{
+			name: "when",
+			in:   "when  a.role ==1  require  a.lag >=0 //x\nwhen a.role require ~a.offset\n",
+			want: "when a.role ==1 require a.lag >=0 //x\nwhen a.role require ~a.offset\n",
+		},
+		{
+			name: "group",
+			in:   "oneof{ b; a }\natleast  2 {//x\nc\nd\n}\n",
+			want: "oneof {\n\tb\n\ta\n}\natleast 2 { //x\n\tc\n\td\n}\n",
+		},
+		{
+			name: "severity",
+			in:   "@warn  a >0\n@info when a require b\n@warn oneof { c; @error d }\n",
+			want: "@warn a >0\n@info when a require b\n@warn oneof {\n\tc\n\t@error d\n}\n",
+		},
+		{
+			name: "labels",
+			in:   "a  [linux , mysql8 ]  >0\nbb [0, 1)\n",
+			want: "a [linux,mysql8] >0\nbb               [0, 1)\n",
+		},
+		{
+			name: "removal",
+			in:   "-a.b  //x\n~c >0\n",
+			want: "-a.b //x\n~c   >0\n",
+		},
+		{
+			name: "include",
+			in:   "include   \"a\\\"b.rules\"//x\nb.c >0\n",
+			want: "include \"a\\\"b.rules\" //x\nb.c >0\n",
+		},
+		{
+			name: "prefix",
+			in:   "let  MAX =1\nprefix a.b {//x\nc >0\n  prefix d{\n\n e <$MAX\n\n\n  ee //y\n}\n}//z\n",
+			want: "let MAX = 1\nprefix a.b { //x\n\tc >0\n\tprefix d {\n\t\te <$MAX\n\n\t\tee //y\n\t}\n} //z\n",
+		},
+		{
+			name: "template",
+			in:   "template nic { rx >=0;tx >=0 } // nic\nuse   nic   at a.#;\nuse nic at b.#\n",
+			want: "template nic {\n\trx >=0\n\ttx >=0\n} // nic\nuse nic at a.#\nuse nic at b.#\n",
+		},
+		{
+			name: "comment only",
+			in:   "a.b.c //x\nb.c\t\t//y",
+			want: "a.b.c //x\nb.c   //y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFile("test", strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("ParseFile: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := Fprint(&buf, f); err != nil {
+				t.Fatalf("Fprint: %v", err)
+			}
+			if s := buf.String(); s != tt.want {
+				t.Errorf("Fprint(%q) = %q; want %q", tt.in, s, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package graphitemetrictest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleFormat represents a format of rule files.
+type RuleFormat interface {
+	ReadRules(r io.Reader) ([]*Rule, error)
+}
+
+// Rule formats.
+//
+// JSONFormat and YAMLFormat read a document that has a list of rules:
+//
+//	rules:
+//	- path: custom.disks.#.reads.bytes
+//	  expr: ">=0"
+//	  description: bytes read from the disk
+//	  owner: storage-team
+//	  links:
+//	  - https://example.com/runbooks/disk
+//	- path: custom.interfaces.#.rx.packets
+//	  optional: true
+//	  severity: warn
+//	- path: custom.redis.replication.lag
+//	  when:
+//	    path: custom.redis.replication.role
+//	    expr: "==1"
+//
+// The expr is written in the same syntax as the rule file.
+// The entry that has "remove: true" removes rules of the path; see Merge.
+var (
+	TextFormat RuleFormat = textFormat{}
+	JSONFormat RuleFormat = jsonFormat{}
+	YAMLFormat RuleFormat = yamlFormat{}
+)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]RuleFormat{
+		".json": JSONFormat,
+		".yaml": YAMLFormat,
+		".yml":  YAMLFormat,
+	}
+)
+
+// RegisterRuleFormat registers the format for files with the extension such as ".toml".
+func RegisterRuleFormat(ext string, f RuleFormat) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[strings.ToLower(ext)] = f
+}
+
+// FormatOf returns the format of the file by its extension.
+// It returns TextFormat for unknown extensions.
+func FormatOf(name string) RuleFormat {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	if f, ok := formats[strings.ToLower(path.Ext(name))]; ok {
+		return f
+	}
+	return TextFormat
+}
+
+type textFormat struct{}
+
+func (textFormat) ReadRules(r io.Reader) ([]*Rule, error) {
+	return ReadRules(r)
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) ReadRules(r io.Reader) ([]*Rule, error) {
+	var doc ruleDoc
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc.rules()
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) ReadRules(r io.Reader) ([]*Rule, error) {
+	var doc ruleDoc
+	d := yaml.NewDecoder(r)
+	d.KnownFields(true)
+	if err := d.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return doc.rules()
+}
+
+// ruleDoc is the schema of structured rule files.
+type ruleDoc struct {
+	Rules []*ruleEntry `json:"rules" yaml:"rules"`
+}
+
+type ruleEntry struct {
+	Path        string     `json:"path" yaml:"path"`
+	Optional    bool       `json:"optional" yaml:"optional"`
+	Remove      bool       `json:"remove" yaml:"remove"`
+	Expr        string     `json:"expr" yaml:"expr"`
+	Description string     `json:"description" yaml:"description"`
+	Owner       string     `json:"owner" yaml:"owner"`
+	Links       []string   `json:"links" yaml:"links"`
+	Labels      []string   `json:"labels" yaml:"labels"`
+	Severity    string     `json:"severity" yaml:"severity"`
+	When        *whenEntry `json:"when" yaml:"when"`
+}
+
+type whenEntry struct {
+	Path string `json:"path" yaml:"path"`
+	Expr string `json:"expr" yaml:"expr"`
+}
+
+func (doc *ruleDoc) rules() ([]*Rule, error) {
+	var rules []*Rule
+	for i, e := range doc.Rules {
+		r, err := e.rule()
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (e *ruleEntry) rule() (*Rule, error) {
+	if e.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	r := &Rule{
+		Required:    !e.Optional,
+		Path:        e.Path,
+		Description: e.Description,
+		Owner:       e.Owner,
+		Links:       e.Links,
+		Labels:      e.Labels,
+		Removed:     e.Remove,
+	}
+	if e.Remove && (e.Expr != "" || len(e.Labels) > 0 || e.When != nil) {
+		return nil, errors.New("remove cannot have expr, labels or when")
+	}
+	for _, s := range e.Labels {
+		if !isLabel(s) {
+			return nil, fmt.Errorf("labels: invalid label %q", s)
+		}
+	}
+	var err error
+	if e.Severity != "" {
+		r.Severity, err = ParseSeverity(e.Severity)
+		if err != nil {
+			return nil, fmt.Errorf("severity: %w", err)
+		}
+	}
+	r.Exprs, err = parseExprString(e.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	if e.When != nil {
+		if e.When.Path == "" {
+			return nil, errors.New("when: path is required")
+		}
+		r.When = &Condition{Path: e.When.Path}
+		r.When.Exprs, err = parseExprString(e.When.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("when: expr: %w", err)
+		}
+	}
+	return r, nil
+}
+
+func parseExprString(s string) ([]*Expr, error) {
+	p := newParser(strings.NewReader(s))
+	exprs, err := p.parseExprs()
+	if err != nil {
+		return nil, err
+	}
+	if t, err := p.readToken(); err != nil || t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q", s)
+	}
+	return evalExprs(exprs, &scope{})
+}
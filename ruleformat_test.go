@@ -0,0 +1,153 @@
+package graphitemetrictest
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRuleFormat(t *testing.T) {
+	want := []*Rule{
+		{
+			Required:    true,
+			Path:        "custom.disks.#.reads.bytes",
+			Exprs:       []*Expr{{Op: GreaterEqual, Value: 0}, {Op: LessThan, Value: 1e9}},
+			Description: "bytes read from the disk",
+			Owner:       "storage",
+			Links:       []string{"https://example.com/disk"},
+		},
+		{
+			Path:     "custom.interfaces.#.rx.packets",
+			Severity: SeverityWarning,
+			Labels:   []string{"linux"},
+		},
+		{
+			Required: true,
+			Path:     "custom.redis.replication.lag",
+			When: &Condition{
+				Path:  "custom.redis.replication.role",
+				Exprs: []*Expr{{Op: Equal, Value: 1}},
+			},
+		},
+	}
+	tests := []struct {
+		name   string
+		format RuleFormat
+		in     string
+	}{
+		{
+			name:   "json",
+			format: JSONFormat,
+			in: `{"rules": [
+				{"path": "custom.disks.#.reads.bytes", "expr": ">=0, <1000000000", "description": "bytes read from the disk", "owner": "storage", "links": ["https://example.com/disk"]},
+				{"path": "custom.interfaces.#.rx.packets", "optional": true, "severity": "warn", "labels": ["linux"]},
+				{"path": "custom.redis.replication.lag", "when": {"path": "custom.redis.replication.role", "expr": "==1"}}
+			]}`,
+		},
+		{
+			name:   "yaml",
+			format: YAMLFormat,
+			in: `rules:
+- path: custom.disks.#.reads.bytes
+  expr: ">=0, <1000000000"
+  description: bytes read from the disk
+  owner: storage
+  links:
+  - https://example.com/disk
+- path: custom.interfaces.#.rx.packets
+  optional: true
+  severity: warn
+  labels: [linux]
+- path: custom.redis.replication.lag
+  when:
+    path: custom.redis.replication.role
+    expr: "==1"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := tt.format.ReadRules(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("ReadRules: %v", err)
+			}
+			if !reflect.DeepEqual(a, want) {
+				t.Errorf("ReadRules = %v; want %v", a, want)
+			}
+		})
+	}
+}
+
+func TestRuleFormat_error(t *testing.T) {
+	tests := []struct {
+		format RuleFormat
+		in     string
+	}{
+		{format: JSONFormat, in: `{"rules": [{"expr": ">0"}]}`},
+		{format: JSONFormat, in: `{"rules": [{"path": "a", "expr": ">0 <1"}]}`},
+		{format: JSONFormat, in: `{"rules": [{"path": "a", "expr": ">0; b"}]}`},
+		{format: JSONFormat, in: `{"rules": [{"path": "a", "exprs": ">0"}]}`},
+		{format: YAMLFormat, in: "rules:\n- path: a\n  expr: <$UNDEFINED_VARIABLE_FOR_TEST\n"},
+		{format: YAMLFormat, in: "rules:\n- path: a\n  min: 1\n"},
+		{format: YAMLFormat, in: "rules:\n- path: a\n  severity: fatal\n"},
+		{format: YAMLFormat, in: "rules:\n- path: a\n  remove: true\n  expr: \">0\"\n"},
+		{format: YAMLFormat, in: "rules:\n- path: a\n  labels: [\"a b\"]\n"},
+	}
+	for _, tt := range tests {
+		_, err := tt.format.ReadRules(strings.NewReader(tt.in))
+		if err == nil {
+			t.Errorf("ReadRules(%q) should return an error", tt.in)
+		}
+	}
+}
+
+type upperFormat struct{}
+
+func (upperFormat) ReadRules(r io.Reader) ([]*Rule, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return []*Rule{{Path: strings.TrimSpace(strings.ToUpper(string(b)))}}, nil
+}
+
+func TestFormatOf(t *testing.T) {
+	RegisterRuleFormat(".upper", upperFormat{})
+	tests := []struct {
+		name   string
+		format RuleFormat
+	}{
+		{name: "a.json", format: JSONFormat},
+		{name: "a.YAML", format: YAMLFormat},
+		{name: "a.yml", format: YAMLFormat},
+		{name: "a.rules", format: TextFormat},
+		{name: "metricrules", format: TextFormat},
+		{name: "a.upper", format: upperFormat{}},
+	}
+	for _, tt := range tests {
+		if f := FormatOf(tt.name); f != tt.format {
+			t.Errorf("FormatOf(%q) = %T; want %T", tt.name, f, tt.format)
+		}
+	}
+}
+
+func TestReadRulesFS_format(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.rules": {Data: []byte("prefix a {\n\tinclude \"b.json\"\n}\ninclude \"c.yml\"\n")},
+		"b.json":     {Data: []byte(`{"rules": [{"path": "b", "expr": ">0"}]}`)},
+		"c.yml":      {Data: []byte("rules:\n- path: c\n")},
+	}
+	want := []*Rule{
+		{Required: true, Path: "a.b", Exprs: []*Expr{{Op: GreaterThan, Value: 0}}},
+		{Required: true, Path: "c"},
+	}
+	a, err := ReadRulesFS(fsys, "main.rules")
+	if err != nil {
+		t.Fatalf("ReadRulesFS: %v", err)
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ReadRulesFS = %v; want %v", a, want)
+	}
+}
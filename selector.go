@@ -0,0 +1,234 @@
+package graphitemetrictest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Selector represents a boolean expression of labels such as "linux && !container".
+//
+// The expression consists of labels, '!' (NOT), '&&' (AND), '||' (OR) and parentheses.
+// The '&&' binds tighter than '||'.
+type Selector struct {
+	x selNode
+}
+
+type selNode interface {
+	match(labels []string) bool
+	String() string
+}
+
+type labelSel string
+
+func (s labelSel) match(labels []string) bool {
+	for _, l := range labels {
+		if l == string(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s labelSel) String() string { return string(s) }
+
+type notSel struct {
+	x selNode
+}
+
+func (s *notSel) match(labels []string) bool { return !s.x.match(labels) }
+func (s *notSel) String() string {
+	if _, ok := s.x.(*binarySel); ok {
+		return "!(" + s.x.String() + ")"
+	}
+	return "!" + s.x.String()
+}
+
+// binarySel is a boolean operation; op is either "&&" or "||".
+type binarySel struct {
+	op   string
+	x, y selNode
+}
+
+func (s *binarySel) match(labels []string) bool {
+	if s.op == "&&" {
+		return s.x.match(labels) && s.y.match(labels)
+	}
+	return s.x.match(labels) || s.y.match(labels)
+}
+
+func (s *binarySel) String() string {
+	x := s.x.String()
+	y := s.y.String()
+	if s.op == "&&" {
+		if b, ok := s.x.(*binarySel); ok && b.op == "||" {
+			x = "(" + x + ")"
+		}
+		if b, ok := s.y.(*binarySel); ok && b.op == "||" {
+			y = "(" + y + ")"
+		}
+	}
+	return x + " " + s.op + " " + y
+}
+
+// ParseSelector parses s as a selector.
+func ParseSelector(s string) (*Selector, error) {
+	p := &selParser{s: s}
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("selector %q: %w", s, err)
+	}
+	if t := p.next(); t != "" {
+		return nil, fmt.Errorf("selector %q: unexpected %s", s, t)
+	}
+	return &Selector{x: x}, nil
+}
+
+// Match returns true if labels satisfy the selector.
+func (s *Selector) Match(labels []string) bool {
+	return s.x.match(labels)
+}
+
+// String returns the representation of the selector.
+func (s *Selector) String() string {
+	return s.x.String()
+}
+
+// Select returns rules selected by sel. Rules without labels are always selected.
+func Select(rules []*Rule, sel *Selector) []*Rule {
+	var a []*Rule
+	for _, r := range rules {
+		if len(r.Labels) == 0 || sel.Match(r.Labels) {
+			a = append(a, r)
+		}
+	}
+	return a
+}
+
+// selParser is a parser of the selector.
+//
+//	or    := and { '||' and }
+//	and   := unary { '&&' unary }
+//	unary := '!' unary | '(' or ')' | label
+type selParser struct {
+	s    string
+	peek string
+}
+
+var errSelEOF = errors.New("unexpected end of the selector")
+
+// next returns the next token; it returns "" at the end of the selector.
+func (p *selParser) next() string {
+	if t := p.peek; t != "" {
+		p.peek = ""
+		return t
+	}
+	p.s = strings.TrimLeftFunc(p.s, unicode.IsSpace)
+	if p.s == "" {
+		return ""
+	}
+	for _, op := range []string{"&&", "||", "!", "(", ")"} {
+		if strings.HasPrefix(p.s, op) {
+			p.s = p.s[len(op):]
+			return op
+		}
+	}
+	n := strings.IndexFunc(p.s, func(c rune) bool { return !isLabelChar(c) })
+	if n < 0 {
+		n = len(p.s)
+	}
+	if n == 0 {
+		// make progress on an invalid character.
+		_, n = utf8.DecodeRuneInString(p.s)
+	}
+	t := p.s[:n]
+	p.s = p.s[n:]
+	return t
+}
+
+func (p *selParser) unread(t string) {
+	p.peek = t
+}
+
+func (p *selParser) parseOr() (selNode, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.next()
+		if t != "||" {
+			p.unread(t)
+			return x, nil
+		}
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &binarySel{op: "||", x: x, y: y}
+	}
+}
+
+func (p *selParser) parseAnd() (selNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.next()
+		if t != "&&" {
+			p.unread(t)
+			return x, nil
+		}
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binarySel{op: "&&", x: x, y: y}
+	}
+}
+
+func (p *selParser) parseUnary() (selNode, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, errSelEOF
+	case t == "!":
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notSel{x: x}, nil
+	case t == "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t != ")" {
+			if t == "" {
+				return nil, errSelEOF
+			}
+			return nil, fmt.Errorf("expected ')', but got %s", t)
+		}
+		return x, nil
+	case isLabel(t):
+		return labelSel(t), nil
+	default:
+		return nil, fmt.Errorf("expected a label, but got %s", t)
+	}
+}
+
+// isLabel returns true if s is a valid label.
+// A label starts with a letter, and it consists of letters, digits, '_', '-' and '.'.
+func isLabel(s string) bool {
+	if c, _ := utf8.DecodeRuneInString(s); !unicode.IsLetter(c) {
+		return false
+	}
+	return strings.IndexFunc(s, func(c rune) bool { return !isLabelChar(c) }) < 0
+}
+
+func isLabelChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' || c == '.'
+}
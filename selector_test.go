@@ -0,0 +1,73 @@
+package graphitemetrictest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		s      string
+		str    string
+		labels []string
+		want   bool
+	}{
+		{s: "linux", str: "linux", labels: []string{"linux"}, want: true},
+		{s: "linux", str: "linux", labels: []string{"darwin"}, want: false},
+		{s: "linux && !container", str: "linux && !container", labels: []string{"linux"}, want: true},
+		{s: "linux && !container", str: "linux && !container", labels: []string{"linux", "container"}, want: false},
+		{s: "linux||darwin&&mysql8", str: "linux || darwin && mysql8", labels: []string{"linux"}, want: true},
+		{s: "(linux||darwin)&&mysql8", str: "(linux || darwin) && mysql8", labels: []string{"linux"}, want: false},
+		{s: "!(a && b)", str: "!(a && b)", labels: []string{"a"}, want: true},
+		{s: "!!mysql-5.7", str: "!!mysql-5.7", labels: []string{"mysql-5.7"}, want: true},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.s)
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %v", tt.s, err)
+			continue
+		}
+		if s := sel.String(); s != tt.str {
+			t.Errorf("ParseSelector(%q).String() = %q; want %q", tt.s, s, tt.str)
+		}
+		if v := sel.Match(tt.labels); v != tt.want {
+			t.Errorf("ParseSelector(%q).Match(%q) = %t; want %t", tt.s, tt.labels, v, tt.want)
+		}
+	}
+}
+
+func TestParseSelector_error(t *testing.T) {
+	tests := []string{
+		"",
+		"linux &&",
+		"linux darwin",
+		"(linux",
+		"linux)",
+		"!",
+		"8linux",
+		"linux & darwin",
+		"linux @ darwin",
+	}
+	for _, s := range tests {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("ParseSelector(%q) should return an error", s)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	rules := []*Rule{
+		{Path: "a"},
+		{Path: "b", Labels: []string{"linux"}},
+		{Path: "c", Labels: []string{"darwin"}},
+		{Path: "d", Labels: []string{"linux", "container"}},
+	}
+	sel, err := ParseSelector("linux && !container")
+	if err != nil {
+		t.Fatalf("ParseSelector: %v", err)
+	}
+	want := []*Rule{rules[0], rules[1]}
+	if a := Select(rules, sel); !reflect.DeepEqual(a, want) {
+		t.Errorf("Select(%v, %v) = %v; want %v", rules, sel, a, want)
+	}
+}
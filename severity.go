@@ -0,0 +1,58 @@
+package graphitemetrictest
+
+import "fmt"
+
+// Severity represents how serious the invalid data is.
+type Severity int
+
+// Severities; the zero value is SeverityError.
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+var severityNames = map[string]Severity{
+	"error":   SeverityError,
+	"warn":    SeverityWarning,
+	"warning": SeverityWarning,
+	"info":    SeverityInfo,
+}
+
+// ParseSeverity returns the severity named s; s is one of "error", "warn" ("warning") and "info".
+func ParseSeverity(s string) (Severity, error) {
+	v, ok := severityNames[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown severity %q", s)
+	}
+	return v, nil
+}
+
+// String returns the name of the severity.
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Severity) UnmarshalText(text []byte) error {
+	v, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
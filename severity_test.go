@@ -0,0 +1,48 @@
+package graphitemetrictest
+
+import "testing"
+
+func TestParseSeverity(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Severity
+		name string
+	}{
+		{s: "error", want: SeverityError, name: "error"},
+		{s: "warn", want: SeverityWarning, name: "warning"},
+		{s: "warning", want: SeverityWarning, name: "warning"},
+		{s: "info", want: SeverityInfo, name: "info"},
+	}
+	for _, tt := range tests {
+		v, err := ParseSeverity(tt.s)
+		if err != nil {
+			t.Fatalf("ParseSeverity(%q): %v", tt.s, err)
+		}
+		if v != tt.want {
+			t.Errorf("ParseSeverity(%q) = %v; want %v", tt.s, v, tt.want)
+		}
+		if s := v.String(); s != tt.name {
+			t.Errorf("%v.String() = %q; want %q", v, s, tt.name)
+		}
+	}
+	if _, err := ParseSeverity("fatal"); err == nil {
+		t.Errorf("ParseSeverity(%q) should return an error", "fatal")
+	}
+}
+
+func TestSeverity_UnmarshalText(t *testing.T) {
+	var s Severity
+	if err := s.UnmarshalText([]byte("info")); err != nil {
+		t.Fatalf("UnmarshalText: %v", err)
+	}
+	if s != SeverityInfo {
+		t.Errorf("UnmarshalText(%q) = %v; want %v", "info", s, SeverityInfo)
+	}
+	b, err := s.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+	if string(b) != "info" {
+		t.Errorf("MarshalText() = %q; want %q", b, "info")
+	}
+}
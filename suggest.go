@@ -0,0 +1,131 @@
+package graphitemetrictest
+
+import "sort"
+
+// maxSuggestDistance is the maximum distance of paths for suggestions; it allows one segment to differ.
+const maxSuggestDistance = 1
+
+// suggest fills Suggestion of unexpected metrics and Renamed of missing rules in results.
+//
+// The suggestion of the unexpected metric is the nearest rule; missing required rules are preferred among ties.
+// If the suggestion is a missing required rule and their paths differ by exactly one segment,
+// they are paired as a rename.
+func suggest(rules []*Rule, results []*InvalidData) {
+	paths := make([][]string, len(rules))
+	index := make(map[int][]int) // indices of rules by the number of segments.
+	for i, r := range rules {
+		paths[i] = splitMetricName(r.Path)
+		index[len(paths[i])] = append(index[len(paths[i])], i)
+	}
+	missing := make(map[*Rule]*InvalidData)
+	for _, d := range results {
+		if d.Rule != nil && d.Metric == nil && d.Rule.Required {
+			missing[d.Rule] = d
+		}
+	}
+	for _, d := range results {
+		if d.Rule != nil || d.Metric == nil {
+			continue
+		}
+		p := splitMetricName(d.Metric.Path)
+		best := float64(maxSuggestDistance)
+		for _, i := range candidates(index, len(p)) {
+			r := rules[i]
+			dist := pathDistance(paths[i], p)
+			if dist > best {
+				continue
+			}
+			if dist < best || d.Suggestion == nil || missing[r] != nil && missing[d.Suggestion] == nil {
+				d.Suggestion = r
+				best = dist
+			}
+		}
+		if v := missing[d.Suggestion]; v != nil && v.Renamed == nil && isRenamed(splitMetricName(v.Rule.Path), p) {
+			v.Renamed = d.Metric
+		}
+	}
+}
+
+// candidates returns indices of rules in order that have n-1 to n+1 segments.
+// Other rules are farther than maxSuggestDistance because inserting or deleting a segment costs 1.
+func candidates(index map[int][]int, n int) []int {
+	var a []int
+	for i := n - maxSuggestDistance; i <= n+maxSuggestDistance; i++ {
+		a = append(a, index[i]...)
+	}
+	sort.Ints(a)
+	return a
+}
+
+// isRenamed returns true if p differs from the rule's path by exactly one segment.
+func isRenamed(pattern, p []string) bool {
+	if len(pattern) != len(p) {
+		return false
+	}
+	n := 0
+	for i, s := range pattern {
+		if s != anyChar && s != p[i] {
+			n++
+		}
+	}
+	return n == 1
+}
+
+// pathDistance returns the edit distance between segments of the rule's path and the metric's path.
+// Inserting or deleting a segment costs 1, and replacing a segment costs between 0.5 and 1
+// in proportion to the edit distance of the segments, so that similar segments are preferred.
+// The wildcard in pattern matches any segment.
+func pathDistance(pattern, p []string) float64 {
+	d := make([]float64, len(p)+1)
+	for j := range d {
+		d[j] = float64(j)
+	}
+	for i := 1; i <= len(pattern); i++ {
+		prev := d[0]
+		d[0] = float64(i)
+		for j := 1; j <= len(p); j++ {
+			cost := 0.0
+			if s := pattern[i-1]; s != anyChar && s != p[j-1] {
+				n := maxInt(len([]rune(s)), len([]rune(p[j-1])))
+				cost = 0.5 + 0.5*float64(editDistance(s, p[j-1]))/float64(n)
+			}
+			v := prev + cost
+			if x := d[j] + 1; x < v {
+				v = x
+			}
+			if x := d[j-1] + 1; x < v {
+				v = x
+			}
+			prev, d[j] = d[j], v
+		}
+	}
+	return d[len(p)]
+}
+
+// editDistance returns the Levenshtein distance between s and t in runes.
+func editDistance(s, t string) int {
+	a, b := []rune(s), []rune(t)
+	d := make([]int, len(b)+1)
+	for j := range d {
+		d[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := d[0]
+		d[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			v := prev + cost
+			if x := d[j] + 1; x < v {
+				v = x
+			}
+			if x := d[j-1] + 1; x < v {
+				v = x
+			}
+			prev, d[j] = d[j], v
+		}
+	}
+	return d[len(b)]
+}
@@ -0,0 +1,110 @@
+package graphitemetrictest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff_suggestion(t *testing.T) {
+	in := `custom.disks.#.read.bytes
+~custom.disks.#.write.bytes
+~custom.mysql.connections
+`
+	rules, err := ReadRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadRules(%q): %v", in, err)
+	}
+	metrics := []*Metric{
+		{Path: "custom.disks.sda.reads.bytes"},
+		{Path: "custom.disks.sdb.reads.bytes"},
+		{Path: "custom.disks.sda.writes.bytes"},
+		{Path: "custom.mysql.connection"},
+		{Path: "custom.mysql.connections.total"},
+		{Path: "custom.redis.keys.expired"},
+	}
+	want := map[string]string{
+		"custom.disks.sda.reads.bytes":   "custom.disks.#.read.bytes",
+		"custom.disks.sdb.reads.bytes":   "custom.disks.#.read.bytes",
+		"custom.disks.sda.writes.bytes":  "custom.disks.#.write.bytes",
+		"custom.mysql.connection":        "custom.mysql.connections",
+		"custom.mysql.connections.total": "custom.mysql.connections",
+		"custom.redis.keys.expired":      "",
+	}
+	var renamed *Metric
+	for _, d := range Diff(rules, metrics) {
+		if d.Rule != nil {
+			if d.Rule != rules[0] {
+				t.Errorf("Diff reports %v; want only %v", d.Rule, rules[0])
+			}
+			renamed = d.Renamed
+			continue
+		}
+		s := ""
+		if d.Suggestion != nil {
+			s = d.Suggestion.Path
+		}
+		if s != want[d.Metric.Path] {
+			t.Errorf("suggestion for %v = %q; want %q", d.Metric, s, want[d.Metric.Path])
+		}
+	}
+	if renamed != metrics[0] {
+		t.Errorf("Renamed = %v; want %v", renamed, metrics[0])
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	index := map[int][]int{1: {2}, 2: {0, 4}, 3: {1}, 5: {3}}
+	tests := []struct {
+		n    int
+		want []int
+	}{
+		{n: 1, want: []int{0, 2, 4}},
+		{n: 2, want: []int{0, 1, 2, 4}},
+		{n: 4, want: []int{1, 3}},
+		{n: 7, want: nil},
+	}
+	for _, tt := range tests {
+		if a := candidates(index, tt.n); !reflect.DeepEqual(a, tt.want) {
+			t.Errorf("candidates(%v, %d) = %v; want %v", index, tt.n, a, tt.want)
+		}
+	}
+}
+
+func TestPathDistance(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          float64
+	}{
+		{pattern: "a.b.c", path: "a.b.c", want: 0},
+		{pattern: "a.*.c", path: "a.b.c", want: 0},
+		{pattern: "a.b", path: "a.b.c", want: 1},
+		{pattern: "a.b.c", path: "a.c", want: 1},
+		{pattern: "a.read", path: "a.reads", want: 0.6},
+		{pattern: "a.b", path: "a.c", want: 1},
+		{pattern: "x.y", path: "a.b", want: 2},
+	}
+	for _, tt := range tests {
+		d := pathDistance(splitMetricName(tt.pattern), splitMetricName(tt.path))
+		if d != tt.want {
+			t.Errorf("pathDistance(%q, %q) = %g; want %g", tt.pattern, tt.path, d, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		s, t string
+		want int
+	}{
+		{s: "", t: "", want: 0},
+		{s: "read", t: "reads", want: 1},
+		{s: "kitten", t: "sitting", want: 3},
+		{s: "日本", t: "日本語", want: 1},
+	}
+	for _, tt := range tests {
+		if d := editDistance(tt.s, tt.t); d != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d; want %d", tt.s, tt.t, d, tt.want)
+		}
+	}
+}
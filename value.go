@@ -0,0 +1,132 @@
+package graphitemetrictest
+
+import (
+	"math"
+	"strconv"
+)
+
+// ValueExpr represents a value derived from the metric value such as "value/1024".
+type ValueExpr interface {
+	// Eval returns the derived value from the metric value.
+	Eval(value float64) float64
+
+	// String returns the representation in the rule syntax.
+	String() string
+}
+
+// valueFuncs are functions that can be called in ValueExpr.
+var valueFuncs = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+}
+
+// valueRef is the metric value itself.
+type valueRef struct{}
+
+func (valueRef) Eval(value float64) float64 { return value }
+func (valueRef) String() string             { return "value" }
+
+type constValue float64
+
+func (c constValue) Eval(value float64) float64 { return float64(c) }
+func (c constValue) String() string {
+	return strconv.FormatFloat(float64(c), 'f', -1, 64)
+}
+
+type negValue struct {
+	x ValueExpr
+}
+
+func (e *negValue) Eval(value float64) float64 { return -e.x.Eval(value) }
+func (e *negValue) String() string {
+	return "-" + wrapValue(e.x, precOf(e.x) < precUnary)
+}
+
+type callValue struct {
+	name string
+	x    ValueExpr
+}
+
+func (e *callValue) Eval(value float64) float64 {
+	return valueFuncs[e.name](e.x.Eval(value))
+}
+
+func (e *callValue) String() string {
+	return e.name + "(" + e.x.String() + ")"
+}
+
+// binaryValue is an arithmetic operation; op is one of "+", "-", "*", "/" and "%".
+type binaryValue struct {
+	op   string
+	x, y ValueExpr
+}
+
+func (e *binaryValue) Eval(value float64) float64 {
+	x := e.x.Eval(value)
+	y := e.y.Eval(value)
+	switch e.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		return x / y
+	case "%":
+		return math.Mod(x, y)
+	default:
+		panic("unknown operator " + e.op)
+	}
+}
+
+func (e *binaryValue) String() string {
+	p := precOf(e)
+	x := wrapValue(e.x, precOf(e.x) < p)
+	y := wrapValue(e.y, precOf(e.y) <= p)
+	return x + formatArithOp(e.op) + y
+}
+
+// Precedences of the value expressions.
+const (
+	precAdd = iota + 1
+	precMul
+	precUnary
+)
+
+func precOf(e ValueExpr) int {
+	switch e := e.(type) {
+	case *binaryValue:
+		return arithPrec(e.op)
+	case *negValue:
+		return precUnary
+	default:
+		return precUnary + 1
+	}
+}
+
+func arithPrec(op string) int {
+	switch op {
+	case "+", "-":
+		return precAdd
+	default:
+		return precMul
+	}
+}
+
+// formatArithOp returns op with spaces if needed.
+// "%" is separated by spaces because "2%" is a number.
+func formatArithOp(op string) string {
+	if op == "%" {
+		return " % "
+	}
+	return op
+}
+
+func wrapValue(e ValueExpr, paren bool) string {
+	if paren {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
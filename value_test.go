@@ -0,0 +1,53 @@
+package graphitemetrictest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValueExpr(t *testing.T) {
+	tests := []struct {
+		in    string
+		value float64
+		want  float64
+		s     string
+	}{
+		{in: "value/1024 <0", value: 2048, want: 2, s: "value/1024"},
+		{in: "abs(value) <0", value: -3, want: 3, s: "abs(value)"},
+		{in: "floor(value/2) <0", value: 5, want: 2, s: "floor(value/2)"},
+		{in: "ceil(value) <0", value: 1.2, want: 2, s: "ceil(value)"},
+		{in: "value % 512 <0", value: 1030, want: 6, s: "value % 512"},
+		{in: "value+1*2 <0", value: 1, want: 3, s: "value+1*2"},
+		{in: "(value+1)*2 <0", value: 1, want: 4, s: "(value+1)*2"},
+		{in: "value-(1-2) <0", value: 1, want: 2, s: "value-(1-2)"},
+		{in: "value - -1 <0", value: 1, want: 2, s: "value--1"},
+		{in: "-value*$X <0", value: 2, want: -6, s: "-value*3"},
+		{in: "-(value+1) <0", value: 2, want: -3, s: "-(value+1)"},
+		{in: "2*value <0", value: 2, want: 4, s: "2*value"},
+		{in: "value/1KiB <0", value: 2048, want: 2, s: "value/1024"},
+	}
+	for _, tt := range tests {
+		in := "let X = 3\na " + tt.in
+		rules, err := ReadRules(strings.NewReader(in))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", in, err)
+		}
+		x := rules[0].Exprs[0].X
+		if v := x.Eval(tt.value); v != tt.want {
+			t.Errorf("%q: Eval(%g) = %g; want %g", tt.in, tt.value, v, tt.want)
+		}
+		if s := x.String(); s != tt.s {
+			t.Errorf("%q: String() = %q; want %q", tt.in, s, tt.s)
+		}
+
+		// String() must be parsed to the same value.
+		in = "a " + x.String() + " <0"
+		a, err := ReadRules(strings.NewReader(in))
+		if err != nil {
+			t.Fatalf("ReadRules(%q): %v", in, err)
+		}
+		if s := a[0].Exprs[0].X.String(); s != tt.s {
+			t.Errorf("ReadRules(%q) = %q; want %q", in, s, tt.s)
+		}
+	}
+}